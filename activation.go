@@ -0,0 +1,70 @@
+package varlink
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+)
+
+// listenFDsStart is the first file descriptor passed by the service manager.
+const listenFDsStart = 3
+
+// ListenActivation returns the listeners passed to the process by the service
+// manager via socket activation, as implemented by systemd. The listeners can
+// be passed to Server.Serve.
+//
+// The LISTEN_PID and LISTEN_FDS environment variables are read. If they are
+// not set, or if LISTEN_PID doesn't match the current process (e.g. because
+// the variables were inherited from a parent process), nil is returned.
+// The variables are unset so that they don't leak to child processes.
+func ListenActivation() ([]net.Listener, error) {
+	n, err := listenFDs()
+	if err != nil || n == 0 {
+		return nil, err
+	}
+
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	listeners := make([]net.Listener, 0, n)
+	for fd := listenFDsStart; fd < listenFDsStart+n; fd++ {
+		f := os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+		ln, err := net.FileListener(f)
+		// net.FileListener duplicates the file descriptor, with the
+		// close-on-exec flag set
+		f.Close()
+		if err != nil {
+			for _, ln := range listeners {
+				ln.Close()
+			}
+			return nil, fmt.Errorf("varlink: file descriptor %v is not a listening socket: %v", fd, err)
+		}
+		listeners = append(listeners, ln)
+	}
+	return listeners, nil
+}
+
+// listenFDs returns the number of file descriptors passed by the service
+// manager, or zero if the process isn't socket-activated.
+func listenFDs() (int, error) {
+	pidStr, fdsStr := os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS")
+	if pidStr == "" || fdsStr == "" {
+		return 0, nil
+	}
+
+	pid, err := strconv.Atoi(pidStr)
+	if err != nil {
+		return 0, fmt.Errorf("varlink: invalid LISTEN_PID %q: %v", pidStr, err)
+	}
+	if pid != os.Getpid() {
+		return 0, nil
+	}
+
+	n, err := strconv.Atoi(fdsStr)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("varlink: invalid LISTEN_FDS %q", fdsStr)
+	}
+	return n, nil
+}
@@ -0,0 +1,91 @@
+package varlink_test
+
+import (
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"testing"
+
+	"github.com/emersion/go-varlink"
+)
+
+// serveActivation is called in a child process started by
+// TestListenActivation.
+func serveActivation() {
+	// The parent process can't know our PID before starting us
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+
+	listeners, err := varlink.ListenActivation()
+	if err != nil {
+		log.Fatalf("ListenActivation() = %v", err)
+	} else if len(listeners) != 1 {
+		log.Fatalf("ListenActivation() returned %v listeners, want 1", len(listeners))
+	}
+	if _, ok := os.LookupEnv("LISTEN_FDS"); ok {
+		log.Fatalf("LISTEN_FDS is still set")
+	}
+
+	srv := varlink.NewServer()
+	srv.Handler = handlerFunc(func(call *varlink.ServerCall, req *varlink.ServerRequest) error {
+		return call.CloseWithReply(map[string]string{"method": req.Method})
+	})
+	log.Fatal(srv.Serve(listeners[0]))
+}
+
+func TestListenActivation(t *testing.T) {
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatalf("os.Executable() = %v", err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen() = %v", err)
+	}
+	defer ln.Close()
+	f, err := ln.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatalf("File() = %v", err)
+	}
+	defer f.Close()
+
+	cmd := exec.Command(exe)
+	cmd.Env = append(os.Environ(), activationServiceEnv+"=1", "LISTEN_FDS=1")
+	cmd.ExtraFiles = []*os.File{f}
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Start() = %v", err)
+	}
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+
+	c, err := varlink.Dial("tcp:" + ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial() = %v", err)
+	}
+	defer c.Close()
+	checkPing(t, c)
+}
+
+func TestListenActivation_notActivated(t *testing.T) {
+	for _, pid := range []string{"", "1"} {
+		t.Setenv("LISTEN_PID", pid)
+		t.Setenv("LISTEN_FDS", "1")
+		listeners, err := varlink.ListenActivation()
+		if err != nil || listeners != nil {
+			t.Errorf("LISTEN_PID=%q: ListenActivation() = %v, %v, want nil, nil", pid, listeners, err)
+		}
+	}
+}
+
+func TestListenActivation_invalid(t *testing.T) {
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "-1")
+	if _, err := varlink.ListenActivation(); err == nil {
+		t.Errorf("ListenActivation() = nil, want an error")
+	}
+}
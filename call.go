@@ -0,0 +1,165 @@
+package varlink
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+	"time"
+)
+
+// CallOption is an option for Client.Call.
+type CallOption func(*callOptions)
+
+type callOptions struct {
+	ctx     context.Context
+	timeout time.Duration
+	more    func() error
+	oneway  bool
+}
+
+// WithContext sets the context of a call. If the context is done before the
+// call completes, Call returns ctx.Err().
+func WithContext(ctx context.Context) CallOption {
+	return func(options *callOptions) {
+		options.ctx = ctx
+	}
+}
+
+// WithTimeout sets a timeout for a call. If the call doesn't complete in
+// time, Call returns context.DeadlineExceeded.
+func WithTimeout(d time.Duration) CallOption {
+	return func(options *callOptions) {
+		options.timeout = d
+	}
+}
+
+// WithMore indicates to the service that multiple replies are expected. The
+// out parameter of Call is populated with each reply in turn, then f is
+// called. If f returns an error, Call returns it and the remaining replies
+// are discarded.
+func WithMore(f func() error) CallOption {
+	return func(options *callOptions) {
+		options.more = f
+	}
+}
+
+// WithOneway indicates to the service that no reply is expected. Call returns
+// as soon as the request has been sent, out is left untouched.
+func WithOneway() CallOption {
+	return func(options *callOptions) {
+		options.oneway = true
+	}
+}
+
+// Call performs a Varlink call with the specified options.
+//
+// Without options, Call is equivalent to Do.
+func (c *Client) Call(method string, in, out interface{}, opts ...CallOption) error {
+	var options callOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	ctx := options.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if options.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, options.timeout)
+		defer cancel()
+	}
+
+	switch {
+	case options.oneway && options.more != nil:
+		return fmt.Errorf("varlink: WithOneway and WithMore are mutually exclusive")
+	case options.oneway:
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return c.DoOneway(method, in)
+	case options.more != nil:
+		return c.callMore(ctx, method, in, out, options.more)
+	default:
+		return c.DoContext(ctx, method, in, out)
+	}
+}
+
+func (c *Client) callMore(ctx context.Context, method string, in, out interface{}, f func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	cc, err := c.DoMore(method, in)
+	if err != nil {
+		return err
+	}
+	for {
+		err := cc.NextContext(ctx, out)
+		if err == io.EOF {
+			return nil
+		} else if ctxErr := ctx.Err(); ctxErr != nil && err == ctxErr {
+			cc.abandon()
+			return err
+		} else if err != nil {
+			return err
+		}
+
+		if err := f(); err != nil {
+			cc.abandon()
+			return err
+		}
+	}
+}
+
+// Call performs a Varlink call with typed parameters. It is similar to
+// Client.Do, but the reply parameters are returned as an Out value.
+//
+// A nil in (e.g. a nil pointer) sends empty parameters, as with Client.Do.
+func Call[In, Out any](c *Client, method string, in In) (Out, error) {
+	var out Out
+	err := c.Do(method, parametersOrNil(in), &out)
+	return out, err
+}
+
+// CallMore performs a Varlink call with typed parameters, indicating to the
+// service that multiple replies are expected. Replies are read with
+// Stream.Next.
+func CallMore[In, Out any](c *Client, method string, in In) (*Stream[Out], error) {
+	cc, err := c.DoMore(method, parametersOrNil(in))
+	if err != nil {
+		return nil, err
+	}
+	return &Stream[Out]{call: cc}, nil
+}
+
+// Stream is a typed wrapper around a ClientCall, returned by CallMore.
+type Stream[Out any] struct {
+	call *ClientCall
+}
+
+// Next waits for a reply. If there are no more replies, io.EOF is returned.
+func (s *Stream[Out]) Next() (Out, error) {
+	var out Out
+	err := s.call.Next(&out)
+	return out, err
+}
+
+// Call returns the underlying call.
+func (s *Stream[Out]) Call() *ClientCall {
+	return s.call
+}
+
+// parametersOrNil returns nil if v is a nil pointer, map or interface, so
+// that typed nil parameters are handled like untyped ones.
+func parametersOrNil(v interface{}) interface{} {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Interface:
+		if rv.IsNil() {
+			return nil
+		}
+	}
+	return v
+}
@@ -0,0 +1,140 @@
+package varlink_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-varlink"
+)
+
+func TestClient_Call(t *testing.T) {
+	release := make(chan struct{})
+	notified := make(chan string, 1)
+	c := newTestClient(t, handlerFunc(func(call *varlink.ServerCall, req *varlink.ServerRequest) error {
+		switch req.Method {
+		case "org.example.test.Monitor":
+			for i := 0; i < 3; i++ {
+				if err := call.Reply(map[string]int{"index": i}); err != nil {
+					return err
+				}
+			}
+			<-release
+			return call.CloseWithReply(map[string]int{"index": 3})
+		case "org.example.test.Notify":
+			notified <- req.Method
+		}
+		return call.CloseWithReply(map[string]string{"method": req.Method})
+	}))
+
+	var out struct {
+		Index  int
+		Method string
+	}
+	if err := c.Call("org.example.test.Ping", nil, &out); err != nil {
+		t.Fatalf("Call() = %v", err)
+	} else if out.Method != "org.example.test.Ping" {
+		t.Errorf("Call() = %q, want %q", out.Method, "org.example.test.Ping")
+	}
+
+	// Context and more combined: the replies sent before the deadline are
+	// received, then the call fails
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	var got []int
+	err := c.Call("org.example.test.Monitor", nil, &out, varlink.WithContext(ctx), varlink.WithMore(func() error {
+		got = append(got, out.Index)
+		return nil
+	}))
+	if err != context.DeadlineExceeded {
+		t.Errorf("Call() = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if len(got) != 3 {
+		t.Errorf("Call() received %v, want 3 replies", got)
+	}
+	close(release)
+
+	// The late reply to Monitor is discarded
+	if err := c.Call("org.example.test.Ping", nil, &out, varlink.WithTimeout(time.Second)); err != nil {
+		t.Fatalf("Call() = %v", err)
+	} else if out.Method != "org.example.test.Ping" {
+		t.Errorf("Call() = %q, want %q", out.Method, "org.example.test.Ping")
+	}
+
+	errStop := errors.New("stop")
+	err = c.Call("org.example.test.Monitor", nil, &out, varlink.WithMore(func() error {
+		return errStop
+	}))
+	if err != errStop {
+		t.Errorf("Call() = %v, want %v", err, errStop)
+	}
+
+	if err := c.Call("org.example.test.Notify", nil, nil, varlink.WithOneway()); err != nil {
+		t.Fatalf("Call() = %v", err)
+	}
+	if method := <-notified; method != "org.example.test.Notify" {
+		t.Errorf("handler received %q, want %q", method, "org.example.test.Notify")
+	}
+
+	err = c.Call("org.example.test.Notify", nil, nil, varlink.WithOneway(), varlink.WithMore(func() error { return nil }))
+	if err == nil {
+		t.Errorf("Call() = nil, want an error for oneway and more")
+	}
+}
+
+func TestCall_generic(t *testing.T) {
+	c := newTestClient(t, handlerFunc(func(call *varlink.ServerCall, req *varlink.ServerRequest) error {
+		if req.Method == "org.example.test.Count" {
+			for i := 0; i < 2; i++ {
+				if err := call.Reply(map[string]int{"n": i}); err != nil {
+					return err
+				}
+			}
+			return call.CloseWithReply(map[string]int{"n": 2})
+		}
+		return call.CloseWithReply(map[string]string{"params": string(req.Parameters)})
+	}))
+
+	type echoIn struct {
+		Text string `json:"text"`
+	}
+	type echoOut struct {
+		Params string `json:"params"`
+	}
+
+	out, err := varlink.Call[*echoIn, echoOut](c, "org.example.test.Echo", &echoIn{Text: "hi"})
+	if err != nil {
+		t.Fatalf("Call() = %v", err)
+	} else if want := `{"text":"hi"}`; out.Params != want {
+		t.Errorf("Call() = %q, want %q", out.Params, want)
+	}
+
+	// A nil pointer is sent as empty parameters
+	ptrOut, err := varlink.Call[*echoIn, *echoOut](c, "org.example.test.Echo", nil)
+	if err != nil {
+		t.Fatalf("Call() = %v", err)
+	} else if ptrOut == nil || ptrOut.Params != "{}" {
+		t.Errorf("Call() = %+v, want empty parameters", ptrOut)
+	}
+
+	type countOut struct{ N int }
+	s, err := varlink.CallMore[struct{}, countOut](c, "org.example.test.Count", struct{}{})
+	if err != nil {
+		t.Fatalf("CallMore() = %v", err)
+	}
+	for i := 0; ; i++ {
+		out, err := s.Next()
+		if err == io.EOF {
+			if i != 3 {
+				t.Errorf("got %v replies, want 3", i)
+			}
+			break
+		} else if err != nil {
+			t.Fatalf("Next() = %v", err)
+		} else if out.N != i {
+			t.Errorf("Next() = %v, want %v", out.N, i)
+		}
+	}
+}
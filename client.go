@@ -1,12 +1,18 @@
 package varlink
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net"
 	"sync"
+	"sync/atomic"
+
+	"github.com/emersion/go-varlink/varlinkdef"
 )
 
 type clientRequest struct {
@@ -38,34 +44,153 @@ func (err *ClientError) Error() string {
 //
 // Client methods are safe to use from multiple goroutines.
 type Client struct {
-	conn *conn
+	conn        *conn
+	iface       *varlinkdef.Interface
+	canonical   bool
+	cacheLookup func(method string, in interface{}) (json.RawMessage, bool)
+	cacheStore  func(method string, in interface{}, out json.RawMessage)
+
+	// Set once the service is known to be able to handle calls
+	ready atomic.Bool
+
+	mutex     sync.Mutex
+	pending   []*pendingCall
+	err       error
+	upgrading bool
+}
+
+// ErrReplyBufferFull is returned by ClientCall.Next when a call created with
+// DoMoreBuffered received more replies than its buffer can hold.
+var ErrReplyBufferFull = errors.New("varlink: reply buffer full")
+
+// pendingCall holds the state of a call waiting for replies.
+type pendingCall struct {
+	ch chan clientReply
+
+	// Only accessed by readLoop
+	bounded bool
+	closed  bool
+	upgrade bool
 
-	mutex   sync.Mutex
-	pending []chan<- clientReply
-	err     error
+	// Set by readLoop before ch is closed
+	err error
+
+	// Protected by Client.mutex. Set when the caller has given up on the
+	// call: its replies are dropped.
+	abandoned bool
 }
 
 // NewClient creates a Varlink client from a net.Conn.
 func NewClient(conn net.Conn) *Client {
-	c := &Client{conn: newConn(conn)}
+	return NewClientWithOptions(conn, nil)
+}
+
+// NewStrictClient creates a Varlink client which validates outgoing request
+// parameters against iface before sending them.
+//
+// Calls to methods not defined in iface, and parameters with unknown, missing
+// or mistyped fields fail locally without reaching the service. Validation
+// has a cost and is meant to be used during development.
+func NewStrictClient(conn net.Conn, iface *varlinkdef.Interface) *Client {
+	return NewClientWithOptions(conn, &ClientOptions{Interface: iface})
+}
+
+// ClientOptions contains options for NewClientWithOptions.
+type ClientOptions struct {
+	// If non-nil, outgoing request parameters are validated against this
+	// interface, see NewStrictClient.
+	Interface *varlinkdef.Interface
+	// If non-nil, a copy of each message sent and received is written to
+	// Tap, for debugging purposes. Each message is written with a single
+	// Write call, prefixed with "-> " for outgoing and "<- " for incoming
+	// messages, and terminated by a newline instead of a NUL byte. Tap may be
+	// written to from multiple goroutines.
+	Tap io.Writer
+	// If true, request parameters are sent in a canonical form: all object
+	// keys are sorted, including struct fields, and no insignificant
+	// whitespace is written. The same parameters thus always produce the
+	// same bytes, regardless of the Go type used to represent them.
+	CanonicalRequests bool
+	// Connection options.
+	Conn ConnOptions
+	// If non-nil, CacheLookup is called by Do before sending a request. If it
+	// returns true, the returned reply parameters are used and no request is
+	// sent. This can be used to cache replies of idempotent methods. Calls
+	// made with DoMore or DoOneway are never cached.
+	CacheLookup func(method string, in interface{}) (out json.RawMessage, ok bool)
+	// If non-nil, CacheStore is called by Do with the parameters of each
+	// successful reply. CacheStore must not modify out.
+	CacheStore func(method string, in interface{}, out json.RawMessage)
+}
+
+// NewClientWithOptions creates a Varlink client from a net.Conn with the
+// specified options. A nil options pointer is equivalent to NewClient.
+func NewClientWithOptions(conn net.Conn, options *ClientOptions) *Client {
+	if options == nil {
+		options = &ClientOptions{}
+	}
+	c := &Client{
+		conn:        newConn(conn, &options.Conn),
+		iface:       options.Interface,
+		canonical:   options.CanonicalRequests,
+		cacheLookup: options.CacheLookup,
+		cacheStore:  options.CacheStore,
+	}
+	c.conn.tap = options.Tap
+	if _, ok := conn.(*execConn); !ok {
+		c.ready.Store(true)
+	}
 	go c.readLoop()
 	return c
 }
 
+// Conn returns the underlying connection.
+//
+// This can be used to inspect the connection (e.g. RemoteAddr) or set socket
+// options. Reading from or writing to the connection directly corrupts the
+// Varlink protocol stream.
+func (c *Client) Conn() net.Conn {
+	return c.conn.Conn
+}
+
+// WaitReady waits until the service is ready to handle calls, or until ctx is
+// done.
+//
+// Clients connected to a socket are ready as soon as they are created:
+// WaitReady returns immediately. For clients created via DialExec or an
+// "exec:" address, WaitReady waits until the service has replied to a call,
+// sending a org.varlink.service.GetInfo call if needed. Errors replied by the
+// service (e.g. if it doesn't implement org.varlink.service) are ignored, since
+// the service is ready to handle calls nonetheless.
+func (c *Client) WaitReady(ctx context.Context) error {
+	if c.ready.Load() {
+		return nil
+	}
+	err := c.DoContext(ctx, "org.varlink.service.GetInfo", nil, nil)
+	if _, ok := err.(*ClientError); ok {
+		return nil
+	}
+	return err
+}
+
 // Close closes the connection.
 func (c *Client) Close() error {
 	return c.conn.Close()
 }
 
-func (c *Client) writeRequest(req *clientRequest, ch chan<- clientReply) error {
+func (c *Client) writeRequest(req *clientRequest, p *pendingCall) error {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
 	if c.err != nil {
 		return c.err
+	} else if c.upgrading {
+		return errUpgrading
 	}
 
-	c.pending = append(c.pending, ch)
+	if p != nil {
+		c.pending = append(c.pending, p)
+	}
 
 	err := c.conn.writeMessage(req)
 	if err != nil {
@@ -73,6 +198,7 @@ func (c *Client) writeRequest(req *clientRequest, ch chan<- clientReply) error {
 		c.conn.Close()
 		return err
 	}
+	c.upgrading = req.Upgrade
 
 	return nil
 }
@@ -85,10 +211,13 @@ func (c *Client) readLoop() {
 
 		if err != nil {
 			c.err = err
+			c.conn.Close()
 		}
 
-		for _, ch := range c.pending {
-			close(ch)
+		for _, p := range c.pending {
+			if !p.closed {
+				close(p.ch)
+			}
 		}
 		c.pending = nil
 	}()
@@ -101,23 +230,61 @@ func (c *Client) readLoop() {
 			}
 			break
 		}
+		c.ready.Store(true)
 
-		var ch chan<- clientReply
+		var p *pendingCall
+		abandoned, upgraded := false, false
 		c.mutex.Lock()
 		if len(c.pending) > 0 {
-			ch = c.pending[0]
+			p = c.pending[0]
+			abandoned = p.abandoned
 			if !reply.Continues {
 				c.pending = c.pending[1:]
 			}
+			if p.upgrade {
+				// No other call can be pending after an upgrade request
+				c.upgrading = false
+				if reply.Error == "" {
+					upgraded = true
+					c.err = errUpgraded
+				}
+			}
 		}
 		c.mutex.Unlock()
 
-		if ch == nil {
-			err = fmt.Errorf("varlink: received reply without request")
+		if p == nil {
+			if reply.Error != "" {
+				err = fmt.Errorf("varlink: received reply without request (error %q, parameters %s)", reply.Error, reply.Parameters)
+			} else {
+				err = fmt.Errorf("varlink: received reply without request (parameters %s)", reply.Parameters)
+			}
 			break
 		}
 
-		ch <- reply
+		if abandoned {
+			// The caller isn't waiting for replies anymore. The call stays
+			// in the queue until its last reply, to keep replies matched
+			// with their calls.
+			continue
+		} else if p.closed {
+			// The call has overflowed, drop its remaining replies
+			continue
+		} else if p.bounded {
+			select {
+			case p.ch <- reply:
+			default:
+				p.err = ErrReplyBufferFull
+				p.closed = true
+				close(p.ch)
+			}
+		} else {
+			p.ch <- reply
+		}
+
+		if upgraded {
+			// The rest of the stream belongs to the caller of Upgrade
+			return
+		}
 	}
 }
 
@@ -126,87 +293,358 @@ func (c *Client) readLoop() {
 // in is a Go value marshaled to a JSON object which contains the request
 // parameters. Similarly, out will be populated with the reply parameters.
 func (c *Client) Do(method string, in, out interface{}) error {
+	return c.DoContext(context.Background(), method, in, out)
+}
+
+// DoContext is similar to Do, but returns ctx.Err() if the context is done
+// before the reply is received. The reply is then discarded when it arrives.
+func (c *Client) DoContext(ctx context.Context, method string, in, out interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if out == nil {
+		out = new(struct{})
+	}
+
+	if c.cacheLookup != nil {
+		if params, ok := c.cacheLookup(method, in); ok {
+			return json.Unmarshal(params, out)
+		}
+	}
+
 	req := clientRequest{
 		Method:     method,
 		Parameters: in,
 	}
-	cc, err := c.do(&req)
+	cc, err := c.do(&req, &pendingCall{ch: make(chan clientReply, 32)})
 	if err != nil {
 		return err
 	}
-	continues, err := cc.next(out)
+	params, continues, err := cc.nextRaw(ctx)
+	if ctxErr := ctx.Err(); ctxErr != nil && err == ctxErr {
+		cc.abandon()
+		return err
+	}
 	if continues {
 		c.conn.Close()
 		return fmt.Errorf("varlink: received continues=true in response to a more=false request")
+	} else if err != nil {
+		return err
 	}
+
+	if c.cacheStore != nil && params != nil {
+		c.cacheStore(method, in, params)
+	}
+	return json.Unmarshal(params, out)
+}
+
+// DoRaw is similar to Do, but returns the raw reply parameters instead of
+// unmarshaling them.
+//
+// If the service replies with an error, the returned ClientError is non-nil.
+// Other errors (e.g. I/O errors) are returned as the last value.
+func (c *Client) DoRaw(method string, in interface{}) (json.RawMessage, *ClientError, error) {
+	var out json.RawMessage
+	err := c.Do(method, in, &out)
+	if verr, ok := err.(*ClientError); ok {
+		return nil, verr, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+	return out, nil, nil
+}
+
+// DoOneway is similar to Do, but indicates to the service that no reply is
+// expected. It returns as soon as the request has been sent.
+func (c *Client) DoOneway(method string, in interface{}) error {
+	req := clientRequest{
+		Method:     method,
+		Parameters: in,
+		Oneway:     true,
+	}
+	_, err := c.do(&req, nil)
 	return err
 }
 
 // DoMore is similar to Do, but indicates to the service that multiple replies
 // are expected.
+//
+// Replies are buffered until they are consumed with ClientCall.Next. When the
+// buffer is full, the client stops reading from the connection until the
+// caller consumes a reply: this pushes back on the service, but also stalls
+// all other calls made with the same Client. Use DoMoreBuffered to avoid
+// this.
 func (c *Client) DoMore(method string, in interface{}) (*ClientCall, error) {
 	req := clientRequest{
 		Method:     method,
 		Parameters: in,
 		More:       true,
 	}
-	return c.do(&req)
+	return c.do(&req, &pendingCall{ch: make(chan clientReply, 32)})
+}
+
+// DoMoreBuffered is similar to DoMore, but never stalls other calls.
+//
+// At most size replies are buffered. If the service sends more replies than
+// that before they are consumed, the call fails: the buffered replies can
+// still be read, then ClientCall.Next returns ErrReplyBufferFull and the
+// remaining replies are discarded.
+func (c *Client) DoMoreBuffered(method string, in interface{}, size int) (*ClientCall, error) {
+	req := clientRequest{
+		Method:     method,
+		Parameters: in,
+		More:       true,
+	}
+	return c.do(&req, &pendingCall{ch: make(chan clientReply, size), bounded: true})
 }
 
-func (c *Client) do(req *clientRequest) (*ClientCall, error) {
+var (
+	errUpgrading = errors.New("varlink: connection upgrade in progress")
+	errUpgraded  = errors.New("varlink: connection has been upgraded")
+)
+
+// Upgrade performs a Varlink call with the upgrade flag set, and returns the
+// underlying connection once the service has replied successfully. The
+// connection can then be used for another protocol.
+//
+// Calls made before Upgrade are still completed, but new calls cannot be
+// made while the upgrade is in progress. Once the connection has been
+// upgraded, all calls fail and the Client only needs to be closed, which
+// also closes the returned connection.
+//
+// If the service replies with an error, the connection is not upgraded and
+// the Client can be used as usual.
+func (c *Client) Upgrade(method string, in interface{}) (net.Conn, error) {
+	req := clientRequest{
+		Method:     method,
+		Parameters: in,
+		Upgrade:    true,
+	}
+	cc, err := c.do(&req, &pendingCall{ch: make(chan clientReply, 1), upgrade: true})
+	if err != nil {
+		return nil, err
+	}
+	if err := cc.Next(nil); err != nil {
+		return nil, err
+	}
+	return &upgradedConn{Conn: c.conn.Conn, br: c.conn.br}, nil
+}
+
+// upgradedConn is a connection handed over by Client.Upgrade. Bytes already
+// buffered by the client are read first.
+type upgradedConn struct {
+	net.Conn
+	br *bufio.Reader
+}
+
+func (c *upgradedConn) Read(b []byte) (int, error) {
+	return c.br.Read(b)
+}
+
+func (c *Client) do(req *clientRequest, p *pendingCall) (*ClientCall, error) {
 	if req.Parameters == nil {
 		req.Parameters = struct{}{}
 	}
 
-	ch := make(chan clientReply, 32)
-	if err := c.writeRequest(req, ch); err != nil {
+	if c.iface != nil {
+		if err := validateParameters(c.iface, req.Method, req.Parameters); err != nil {
+			return nil, fmt.Errorf("varlink: invalid call to %q: %v", req.Method, err)
+		}
+	}
+
+	if c.canonical {
+		params, err := canonicalJSON(req.Parameters)
+		if err != nil {
+			return nil, fmt.Errorf("varlink: failed to marshal parameters for %q: %v", req.Method, err)
+		}
+		req.Parameters = params
+	}
+
+	if err := c.writeRequest(req, p); err != nil {
 		return nil, err
 	}
+	if p == nil {
+		return nil, nil
+	}
 
 	return &ClientCall{
 		c:  c,
-		ch: ch,
+		p:  p,
+		ch: p.ch,
 	}, nil
 }
 
+// canonicalJSON marshals v with sorted object keys. Numbers are kept as-is.
+func canonicalJSON(v interface{}) (json.RawMessage, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var generic interface{}
+	if err := dec.Decode(&generic); err != nil {
+		return nil, err
+	}
+
+	// encoding/json sorts map keys
+	return json.Marshal(generic)
+}
+
 // ClientCall represents an in-progress Varlink method call.
+//
+// Unlike Client, ClientCall is not safe to use from multiple goroutines: Next
+// and NextDecoder must not be called concurrently.
 type ClientCall struct {
-	c  *Client
-	ch <-chan clientReply
+	c    *Client
+	p    *pendingCall
+	ch   <-chan clientReply
+	busy atomic.Bool
+	err  error // terminal error, set when ch is cleared
 }
 
+var errConcurrentNext = errors.New("varlink: ClientCall.Next called concurrently")
+
 // Next waits for a reply.
 //
 // If there are no more replies, io.EOF is returned.
 func (cc *ClientCall) Next(out interface{}) error {
+	return cc.NextContext(context.Background(), out)
+}
+
+// NextContext is similar to Next, but returns ctx.Err() if the context is
+// done before a reply is received.
+//
+// The call is left untouched in this case: no reply is lost, and NextContext
+// can be called again to keep waiting. The call stays pending on the Client
+// until its last reply is received, and incoming replies keep being buffered
+// as described in DoMore, even if the caller stops calling Next.
+func (cc *ClientCall) NextContext(ctx context.Context, out interface{}) error {
+	if !cc.busy.CompareAndSwap(false, true) {
+		return errConcurrentNext
+	}
+	defer cc.busy.Store(false)
+
 	if cc.ch == nil {
 		return io.EOF
 	}
 
-	continues, err := cc.next(out)
+	continues, err := cc.next(ctx, out)
+	if ctxErr := ctx.Err(); ctxErr != nil && err == ctxErr {
+		return err
+	}
 	if !continues {
 		cc.ch = nil
+		cc.err = err
 	}
 	return err
 }
 
-func (cc *ClientCall) next(out interface{}) (continues bool, err error) {
+// NextDecoder is similar to Next, but returns a JSON decoder for the reply
+// parameters instead of unmarshaling them. This allows callers to
+// incrementally decode large replies.
+//
+// The returned decoder is only valid until the next call to Next or
+// NextDecoder.
+func (cc *ClientCall) NextDecoder() (*json.Decoder, error) {
+	if !cc.busy.CompareAndSwap(false, true) {
+		return nil, errConcurrentNext
+	}
+	defer cc.busy.Store(false)
+
+	if cc.ch == nil {
+		return nil, io.EOF
+	}
+
+	params, continues, err := cc.nextRaw(context.Background())
+	if !continues {
+		cc.ch = nil
+		cc.err = err
+	}
+	if err != nil {
+		return nil, err
+	}
+	return json.NewDecoder(bytes.NewReader(params)), nil
+}
+
+// abandon drops all future replies of the call.
+func (cc *ClientCall) abandon() {
+	cc.c.mutex.Lock()
+	cc.p.abandoned = true
+	cc.c.mutex.Unlock()
+}
+
+// Err returns the error which terminated the call, if any.
+//
+// Once Next has returned io.EOF, Err returns nil if the last reply was
+// successful, or the error returned for the last reply otherwise (e.g. a
+// *ClientError sent by the service, or an I/O error). Err returns nil while
+// the call is still in progress.
+func (cc *ClientCall) Err() error {
+	return cc.err
+}
+
+// CollectAll reads all remaining replies of a call. factory is called to
+// allocate the value each reply is unmarshaled into.
+//
+// If a reply fails, the values collected so far are returned along with the
+// error.
+func CollectAll(cc *ClientCall, factory func() interface{}) ([]interface{}, error) {
+	var l []interface{}
+	for {
+		v := factory()
+		if err := cc.Next(v); err == io.EOF {
+			return l, nil
+		} else if err != nil {
+			return l, err
+		}
+		l = append(l, v)
+	}
+}
+
+func (cc *ClientCall) next(ctx context.Context, out interface{}) (continues bool, err error) {
 	if out == nil {
 		out = new(struct{})
 	}
 
-	reply, ok := <-cc.ch
-	if !ok {
-		return false, cc.c.err
+	params, continues, err := cc.nextRaw(ctx)
+	if err != nil {
+		return continues, err
 	}
+	return continues, json.Unmarshal(params, out)
+}
 
-	if reply.Error != "" {
-		return reply.Continues, &ClientError{Name: reply.Error, Parameters: reply.Parameters}
+func (cc *ClientCall) nextRaw(ctx context.Context) (params json.RawMessage, continues bool, err error) {
+	var (
+		reply clientReply
+		ok    bool
+	)
+	select {
+	case reply, ok = <-cc.ch:
+	case <-ctx.Done():
+		return nil, false, ctx.Err()
+	}
+	if !ok {
+		if cc.p.err != nil {
+			return nil, false, cc.p.err
+		} else if cc.c.err != nil {
+			return nil, false, cc.c.err
+		}
+		// The connection has been closed locally
+		return nil, false, net.ErrClosed
 	}
 
-	params := reply.Parameters
+	params = reply.Parameters
 	if params == nil {
 		params = json.RawMessage("{}")
+	} else if params[0] != '{' && !bytes.Equal(params, []byte("null")) {
+		return nil, reply.Continues, fmt.Errorf("varlink: reply parameters must be a JSON object, got %s", params)
+	}
+
+	if reply.Error != "" {
+		return nil, reply.Continues, &ClientError{Name: reply.Error, Parameters: params}
 	}
-	return reply.Continues, json.Unmarshal(params, out)
+	return params, reply.Continues, nil
 }
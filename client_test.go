@@ -0,0 +1,853 @@
+package varlink_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-varlink"
+	"github.com/emersion/go-varlink/varlinkdef"
+)
+
+type handlerFunc func(call *varlink.ServerCall, req *varlink.ServerRequest) error
+
+func (f handlerFunc) HandleVarlink(call *varlink.ServerCall, req *varlink.ServerRequest) error {
+	return f(call, req)
+}
+
+// newTestListener listens on a local TCP port. The listener is closed when
+// the test ends.
+func newTestListener(t *testing.T) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen() = %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	return ln
+}
+
+// dialTestConn connects to ln. The connection is closed when the test ends.
+func dialTestConn(t *testing.T, ln net.Listener) net.Conn {
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("net.Dial() = %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// newTestConn starts a server with the handler h and connects to it. The
+// configure functions are called on the server before it starts serving.
+func newTestConn(t *testing.T, h varlink.Handler, configure ...func(*varlink.Server)) net.Conn {
+	ln := newTestListener(t)
+
+	srv := varlink.NewServer()
+	srv.Handler = h
+	for _, f := range configure {
+		f(srv)
+	}
+	go srv.Serve(ln)
+
+	return dialTestConn(t, ln)
+}
+
+func newTestClient(t *testing.T, h varlink.Handler, configure ...func(*varlink.Server)) *varlink.Client {
+	c := varlink.NewClient(newTestConn(t, h, configure...))
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestClientCall_NextDecoder(t *testing.T) {
+	const n = 10000
+
+	c := newTestClient(t, handlerFunc(func(call *varlink.ServerCall, req *varlink.ServerRequest) error {
+		items := make([]int, n)
+		for i := range items {
+			items[i] = i
+		}
+		return call.CloseWithReply(map[string]interface{}{"items": items})
+	}))
+
+	cc, err := c.DoMore("org.example.test.List", nil)
+	if err != nil {
+		t.Fatalf("DoMore() = %v", err)
+	}
+	dec, err := cc.NextDecoder()
+	if err != nil {
+		t.Fatalf("NextDecoder() = %v", err)
+	}
+
+	for _, want := range []json.Token{json.Delim('{'), "items", json.Delim('[')} {
+		if tok, err := dec.Token(); err != nil {
+			t.Fatalf("Token() = %v", err)
+		} else if tok != want {
+			t.Fatalf("Token() = %v, want %v", tok, want)
+		}
+	}
+
+	i := 0
+	for dec.More() {
+		var v int
+		if err := dec.Decode(&v); err != nil {
+			t.Fatalf("Decode() = %v", err)
+		}
+		if v != i {
+			t.Fatalf("item %v = %v", i, v)
+		}
+		i++
+	}
+	if i != n {
+		t.Errorf("got %v items, want %v", i, n)
+	}
+
+	if _, err := cc.NextDecoder(); err != io.EOF {
+		t.Errorf("NextDecoder() = %v, want io.EOF", err)
+	}
+}
+
+func TestClientCall_concurrentNext(t *testing.T) {
+	release := make(chan struct{})
+	c := newTestClient(t, handlerFunc(func(call *varlink.ServerCall, req *varlink.ServerRequest) error {
+		<-release
+		return call.CloseWithReply(nil)
+	}))
+
+	cc, err := c.DoMore("org.example.test.Wait", nil)
+	if err != nil {
+		t.Fatalf("DoMore() = %v", err)
+	}
+
+	errCh := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			errCh <- cc.Next(nil)
+		}()
+	}
+
+	// The server doesn't reply before being released, so the first result
+	// must come from the guard
+	if err := <-errCh; err == nil {
+		t.Errorf("Next() succeeded while another Next() was in progress")
+	}
+	close(release)
+	if err := <-errCh; err != nil {
+		t.Errorf("Next() = %v", err)
+	}
+}
+
+func TestClient_replyWithoutRequest(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer serverConn.Close()
+
+	c := varlink.NewClient(clientConn)
+	defer c.Close()
+
+	if _, err := serverConn.Write([]byte(`{"parameters":{"stray":42}}` + "\x00")); err != nil {
+		t.Fatalf("Write() = %v", err)
+	}
+	// Wait for the client to tear down the connection
+	if _, err := serverConn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("Read() = %v, want io.EOF", err)
+	}
+
+	err := c.Do("org.example.test.Ping", nil, nil)
+	if err == nil || !strings.Contains(err.Error(), `{"stray":42}`) {
+		t.Errorf("Do() = %v, want error containing the stray reply", err)
+	}
+}
+
+func TestNewStrictClient(t *testing.T) {
+	iface, err := varlinkdef.ReadString(`interface org.example.test
+
+type Point (x: int, y: int)
+
+method Move(
+  point: Point,
+  speed: ?float,
+  mode: (walk, run),
+  tags: [string]string
+) -> ()
+`)
+	if err != nil {
+		t.Fatalf("ReadString() = %v", err)
+	}
+
+	conn := newTestConn(t, handlerFunc(func(call *varlink.ServerCall, req *varlink.ServerRequest) error {
+		return call.CloseWithReply(nil)
+	}))
+	c := varlink.NewStrictClient(conn, iface)
+	defer c.Close()
+
+	tests := []struct {
+		name   string
+		method string
+		in     string
+		valid  bool
+	}{
+		{"valid", "org.example.test.Move", `{"point": {"x": 1, "y": 2}, "mode": "run", "tags": {}}`, true},
+		{"nullable", "org.example.test.Move", `{"point": {"x": 1, "y": 2}, "speed": 4.2, "mode": "walk", "tags": {"a": "b"}}`, true},
+		{"unknownMethod", "org.example.test.Jump", `{}`, false},
+		{"foreignMethod", "org.example.other.Move", `{"point": {"x": 1, "y": 2}, "mode": "run", "tags": {}}`, false},
+		{"extraField", "org.example.test.Move", `{"point": {"x": 1, "y": 2}, "mode": "run", "tags": {}, "extra": 1}`, false},
+		{"missingField", "org.example.test.Move", `{"point": {"x": 1, "y": 2}, "tags": {}}`, false},
+		{"missingNestedField", "org.example.test.Move", `{"point": {"x": 1}, "mode": "run", "tags": {}}`, false},
+		{"invalidEnum", "org.example.test.Move", `{"point": {"x": 1, "y": 2}, "mode": "fly", "tags": {}}`, false},
+		{"wrongType", "org.example.test.Move", `{"point": {"x": 1.5, "y": 2}, "mode": "run", "tags": {}}`, false},
+		{"wrongMapValue", "org.example.test.Move", `{"point": {"x": 1, "y": 2}, "mode": "run", "tags": {"a": 1}}`, false},
+		{"null", "org.example.test.Move", `{"point": null, "mode": "run", "tags": {}}`, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := c.Do(tc.method, json.RawMessage(tc.in), nil)
+			if tc.valid && err != nil {
+				t.Errorf("Do() = %v", err)
+			} else if !tc.valid && err == nil {
+				t.Errorf("Do() = nil, want an error")
+			} else if _, ok := err.(*varlink.ClientError); ok {
+				t.Errorf("Do() = %v, want a local error", err)
+			}
+		})
+	}
+}
+
+func TestClient_DoMoreBuffered(t *testing.T) {
+	const n = 10
+
+	c := newTestClient(t, handlerFunc(func(call *varlink.ServerCall, req *varlink.ServerRequest) error {
+		if req.Method == "org.example.test.Stream" {
+			for i := 0; i < n-1; i++ {
+				if err := call.Reply(map[string]int{"i": i}); err != nil {
+					return err
+				}
+			}
+			return call.CloseWithReply(map[string]int{"i": n - 1})
+		}
+		return call.CloseWithReply(nil)
+	}))
+
+	cc, err := c.DoMoreBuffered("org.example.test.Stream", nil, 2)
+	if err != nil {
+		t.Fatalf("DoMoreBuffered() = %v", err)
+	}
+
+	// The stream isn't consumed, but doesn't block other calls
+	if err := c.Do("org.example.test.Ping", nil, nil); err != nil {
+		t.Fatalf("Do() = %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		var out struct{ I int }
+		if err := cc.Next(&out); err != nil {
+			t.Fatalf("Next() = %v", err)
+		} else if out.I != i {
+			t.Errorf("Next() = %v, want %v", out.I, i)
+		}
+	}
+	if err := cc.Next(nil); err != varlink.ErrReplyBufferFull {
+		t.Errorf("Next() = %v, want ErrReplyBufferFull", err)
+	}
+}
+
+type lockedBuffer struct {
+	mutex sync.Mutex
+	buf   bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.String()
+}
+
+func TestTap(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen() = %v", err)
+	}
+	defer ln.Close()
+
+	var serverTap, clientTap lockedBuffer
+	srv := varlink.NewServer()
+	srv.Tap = &serverTap
+	srv.Handler = handlerFunc(func(call *varlink.ServerCall, req *varlink.ServerRequest) error {
+		return call.CloseWithReply(map[string]string{"pong": "hi"})
+	})
+	go srv.Serve(ln)
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("net.Dial() = %v", err)
+	}
+	c := varlink.NewClientWithOptions(conn, &varlink.ClientOptions{Tap: &clientTap})
+	defer c.Close()
+
+	if err := c.Do("org.example.test.Ping", map[string]string{"ping": "hi"}, nil); err != nil {
+		t.Fatalf("Do() = %v", err)
+	}
+
+	const (
+		req   = `{"method":"org.example.test.Ping","parameters":{"ping":"hi"}}`
+		reply = `{"parameters":{"pong":"hi"}}`
+	)
+	if got, want := clientTap.String(), "-> "+req+"\n<- "+reply+"\n"; got != want {
+		t.Errorf("client tap = %q, want %q", got, want)
+	}
+	if got, want := serverTap.String(), "<- "+req+"\n-> "+reply+"\n"; got != want {
+		t.Errorf("server tap = %q, want %q", got, want)
+	}
+}
+
+func TestClient_DoRaw(t *testing.T) {
+	c := newTestClient(t, handlerFunc(func(call *varlink.ServerCall, req *varlink.ServerRequest) error {
+		switch req.Method {
+		case "org.example.test.Get":
+			return call.CloseWithReply(map[string]int{"n": 42})
+		default:
+			return &varlink.ServerError{
+				Name:       "org.example.test.Failed",
+				Parameters: map[string]string{"reason": "nope"},
+			}
+		}
+	}))
+
+	params, verr, err := c.DoRaw("org.example.test.Get", nil)
+	if err != nil || verr != nil {
+		t.Fatalf("DoRaw(Get) = %v, %v", verr, err)
+	}
+	if string(params) != `{"n":42}` {
+		t.Errorf("DoRaw(Get) = %s, want %s", params, `{"n":42}`)
+	}
+
+	params, verr, err = c.DoRaw("org.example.test.Fail", nil)
+	if err != nil {
+		t.Fatalf("DoRaw(Fail) = %v", err)
+	}
+	if params != nil {
+		t.Errorf("DoRaw(Fail) = %s, want nil parameters", params)
+	}
+	if verr == nil || verr.Name != "org.example.test.Failed" || string(verr.Parameters) != `{"reason":"nope"}` {
+		t.Errorf("DoRaw(Fail) error = %#v", verr)
+	}
+
+	c.Close()
+	if _, verr, err := c.DoRaw("org.example.test.Get", nil); err == nil || verr != nil {
+		t.Errorf("DoRaw() after Close = %v, %v, want a transport error", verr, err)
+	}
+}
+
+func TestClient_errorWithoutParameters(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer serverConn.Close()
+
+	c := varlink.NewClient(clientConn)
+	defer c.Close()
+
+	go func() {
+		br := bufio.NewReader(serverConn)
+		if _, err := br.ReadBytes(0); err != nil {
+			return
+		}
+		serverConn.Write([]byte(`{"error":"org.example.test.Failed"}` + "\x00"))
+	}()
+
+	err := c.Do("org.example.test.Ping", nil, nil)
+	verr, ok := err.(*varlink.ClientError)
+	if !ok {
+		t.Fatalf("Do() = %v, want a *varlink.ClientError", err)
+	}
+	if verr.Name != "org.example.test.Failed" || string(verr.Parameters) != "{}" {
+		t.Errorf("Do() = %#v, want empty parameters", verr)
+	}
+}
+
+func TestClient_nilOutError(t *testing.T) {
+	c := newTestClient(t, handlerFunc(func(call *varlink.ServerCall, req *varlink.ServerRequest) error {
+		return &varlink.ServerError{
+			Name:       "org.example.test.Failed",
+			Parameters: map[string]interface{}{"reason": "broken", "code": 42},
+		}
+	}))
+
+	const wantParams = `{"code":42,"reason":"broken"}`
+	check := func(name string, err error) {
+		t.Helper()
+		verr, ok := err.(*varlink.ClientError)
+		if !ok {
+			t.Fatalf("%v() = %v, want a *varlink.ClientError", name, err)
+		}
+		if verr.Name != "org.example.test.Failed" || string(verr.Parameters) != wantParams {
+			t.Errorf("%v() = %v with parameters %s, want parameters %s", name, verr.Name, verr.Parameters, wantParams)
+		}
+	}
+
+	check("Do", c.Do("org.example.test.Fail", nil, nil))
+
+	call, err := c.DoMore("org.example.test.Fail", nil)
+	if err != nil {
+		t.Fatalf("DoMore() = %v", err)
+	}
+	err = call.Next(nil)
+	check("Next", err)
+}
+
+func TestClientCall_Err(t *testing.T) {
+	c := newTestClient(t, handlerFunc(func(call *varlink.ServerCall, req *varlink.ServerRequest) error {
+		if err := call.Reply(nil); err != nil {
+			return err
+		}
+		if req.Method == "org.example.test.Fail" {
+			return &varlink.ServerError{Name: "org.example.test.Failed"}
+		}
+		return call.CloseWithReply(nil)
+	}))
+
+	tests := []struct {
+		method  string
+		errName string
+	}{
+		{"org.example.test.List", ""},
+		{"org.example.test.Fail", "org.example.test.Failed"},
+	}
+
+	for _, tc := range tests {
+		cc, err := c.DoMore(tc.method, nil)
+		if err != nil {
+			t.Fatalf("DoMore() = %v", err)
+		}
+		for {
+			if err := cc.Next(nil); err == io.EOF {
+				break
+			} else if err == nil && cc.Err() != nil {
+				t.Errorf("%v: Err() = %v in the middle of the stream", tc.method, cc.Err())
+			}
+		}
+
+		err = cc.Err()
+		if tc.errName == "" {
+			if err != nil {
+				t.Errorf("%v: Err() = %v, want nil", tc.method, err)
+			}
+		} else if verr, ok := err.(*varlink.ClientError); !ok || verr.Name != tc.errName {
+			t.Errorf("%v: Err() = %v, want %v", tc.method, err, tc.errName)
+		}
+	}
+}
+
+func TestClientOptions_CanonicalRequests(t *testing.T) {
+	type pointAB struct {
+		B    int               `json:"b"`
+		A    float64           `json:"a"`
+		Tags map[string]string `json:"tags"`
+	}
+	type pointBA struct {
+		Tags map[string]string `json:"tags"`
+		A    float64           `json:"a"`
+		B    int               `json:"b"`
+	}
+
+	var tap lockedBuffer
+	c := varlink.NewClientWithOptions(newTestConn(t, handlerFunc(func(call *varlink.ServerCall, req *varlink.ServerRequest) error {
+		return call.CloseWithReply(nil)
+	})), &varlink.ClientOptions{Tap: &tap, CanonicalRequests: true})
+	defer c.Close()
+
+	tags := map[string]string{"z": "1", "m": "2"}
+	inputs := []interface{}{
+		pointAB{B: 2, A: 1.5, Tags: tags},
+		pointBA{B: 2, A: 1.5, Tags: tags},
+		map[string]interface{}{"tags": tags, "b": 2, "a": 1.5},
+	}
+	for _, in := range inputs {
+		if err := c.Do("org.example.test.Set", in, nil); err != nil {
+			t.Fatalf("Do() = %v", err)
+		}
+	}
+
+	const req = `-> {"method":"org.example.test.Set","parameters":{"a":1.5,"b":2,"tags":{"m":"2","z":"1"}}}`
+	n := 0
+	for _, line := range strings.Split(tap.String(), "\n") {
+		if !strings.HasPrefix(line, "-> ") {
+			continue
+		}
+		if line != req {
+			t.Errorf("request %v = %v, want %v", n, line, req)
+		}
+		n++
+	}
+	if n != len(inputs) {
+		t.Errorf("got %v requests, want %v", n, len(inputs))
+	}
+}
+
+func TestClient_arrayReply(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer serverConn.Close()
+
+	c := varlink.NewClient(clientConn)
+	defer c.Close()
+
+	go func() {
+		br := bufio.NewReader(serverConn)
+		if _, err := br.ReadBytes(0); err != nil {
+			return
+		}
+		serverConn.Write([]byte(`{"parameters":[{"cpu":1}]}` + "\x00"))
+	}()
+
+	var out []struct{ CPU int }
+	err := c.Do("org.example.test.GetLoads", nil, &out)
+	if err == nil || !strings.Contains(err.Error(), "JSON object") {
+		t.Errorf("Do() = %v, want an error for non-object parameters", err)
+	}
+}
+
+func TestClient_Conn(t *testing.T) {
+	conn := newTestConn(t, handlerFunc(func(call *varlink.ServerCall, req *varlink.ServerRequest) error {
+		return call.CloseWithReply(nil)
+	}))
+	c := varlink.NewClient(conn)
+	defer c.Close()
+
+	if c.Conn() != conn {
+		t.Errorf("Conn() = %v, want %v", c.Conn(), conn)
+	}
+	if addr := c.Conn().RemoteAddr(); addr == nil || addr.String() != conn.RemoteAddr().String() {
+		t.Errorf("Conn().RemoteAddr() = %v, want %v", addr, conn.RemoteAddr())
+	}
+}
+
+func TestConnOptions_MaxMessageSize(t *testing.T) {
+	options := varlink.ConnOptions{
+		// Smaller than the messages, to exercise partial reads
+		ReadBufferSize: 16,
+		MaxMessageSize: 128,
+	}
+	handler := handlerFunc(func(call *varlink.ServerCall, req *varlink.ServerRequest) error {
+		var in struct{ Text string }
+		if err := json.Unmarshal(req.Parameters, &in); err != nil {
+			return err
+		}
+		return call.CloseWithReply(map[string]string{"text": in.Text + in.Text})
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen() = %v", err)
+	}
+	defer ln.Close()
+
+	srv := varlink.NewServer()
+	srv.Handler = handler
+	srv.ConnOptions = options
+	go srv.Serve(ln)
+
+	dial := func() *varlink.Client {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatalf("net.Dial() = %v", err)
+		}
+		c := varlink.NewClientWithOptions(conn, &varlink.ClientOptions{Conn: options})
+		t.Cleanup(func() { c.Close() })
+		return c
+	}
+
+	var out struct{ Text string }
+	if err := dial().Do("org.example.test.Echo", map[string]string{"text": "hi"}, &out); err != nil {
+		t.Fatalf("Do() = %v", err)
+	} else if out.Text != "hihi" {
+		t.Errorf("Do() = %q, want %q", out.Text, "hihi")
+	}
+
+	// The request fits, but the reply doesn't: the client rejects it
+	text := strings.Repeat("a", 60)
+	err = dial().Do("org.example.test.Echo", map[string]string{"text": text}, &out)
+	if err == nil || !strings.Contains(err.Error(), "exceeds") {
+		t.Errorf("Do() = %v, want an error for an oversized reply", err)
+	}
+
+	// The request doesn't fit: the server closes the connection
+	text = strings.Repeat("a", 100)
+	err = dial().Do("org.example.test.Echo", map[string]string{"text": text}, &out)
+	if err == nil {
+		t.Errorf("Do() = %v, want an error for an oversized request", err)
+	}
+}
+
+func TestClientOptions_cache(t *testing.T) {
+	var mutex sync.Mutex
+	calls := make(map[string]int)
+	conn := newTestConn(t, handlerFunc(func(call *varlink.ServerCall, req *varlink.ServerRequest) error {
+		mutex.Lock()
+		calls[req.Method]++
+		n := calls[req.Method]
+		mutex.Unlock()
+		if req.More {
+			return call.CloseWithReply(nil)
+		}
+		return call.CloseWithReply(map[string]int{"n": n})
+	}))
+
+	cache := make(map[string]json.RawMessage)
+	c := varlink.NewClientWithOptions(conn, &varlink.ClientOptions{
+		CacheLookup: func(method string, in interface{}) (json.RawMessage, bool) {
+			if method != "org.example.test.GetInfo" {
+				return nil, false
+			}
+			out, ok := cache[method]
+			return out, ok
+		},
+		CacheStore: func(method string, in interface{}, out json.RawMessage) {
+			cache[method] = append(json.RawMessage(nil), out...)
+		},
+	})
+	defer c.Close()
+
+	for i := 0; i < 2; i++ {
+		var out struct{ N int }
+		if err := c.Do("org.example.test.GetInfo", nil, &out); err != nil {
+			t.Fatalf("Do(GetInfo) = %v", err)
+		} else if out.N != 1 {
+			t.Errorf("Do(GetInfo) #%v = %v, want 1", i, out.N)
+		}
+
+		if err := c.Do("org.example.test.Uncached", nil, &out); err != nil {
+			t.Fatalf("Do(Uncached) = %v", err)
+		} else if out.N != i+1 {
+			t.Errorf("Do(Uncached) #%v = %v, want %v", i, out.N, i+1)
+		}
+	}
+
+	// More calls bypass the cache
+	cc, err := c.DoMore("org.example.test.GetInfo", nil)
+	if err != nil {
+		t.Fatalf("DoMore() = %v", err)
+	}
+	if err := cc.Next(nil); err != nil {
+		t.Fatalf("Next() = %v", err)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if calls["org.example.test.GetInfo"] != 2 {
+		t.Errorf("server received %v GetInfo calls, want 2", calls["org.example.test.GetInfo"])
+	}
+}
+
+func TestClientOptions_cacheClosed(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+	conn := newTestConn(t, handlerFunc(func(call *varlink.ServerCall, req *varlink.ServerRequest) error {
+		close(started)
+		<-release
+		return call.CloseWithReply(nil)
+	}))
+
+	stored := false
+	c := varlink.NewClientWithOptions(conn, &varlink.ClientOptions{
+		CacheLookup: func(method string, in interface{}) (json.RawMessage, bool) {
+			return nil, false
+		},
+		CacheStore: func(method string, in interface{}, out json.RawMessage) {
+			stored = true
+		},
+	})
+
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Do("org.example.test.GetInfo", nil, nil)
+	}()
+	<-started
+	c.Close()
+
+	if err := <-done; !errors.Is(err, net.ErrClosed) {
+		t.Errorf("Do() = %v, want %v", err, net.ErrClosed)
+	}
+	if stored {
+		t.Errorf("CacheStore called for a call interrupted by Close")
+	}
+}
+
+func TestClient_DoContext(t *testing.T) {
+	release := make(chan struct{})
+	c := newTestClient(t, handlerFunc(func(call *varlink.ServerCall, req *varlink.ServerRequest) error {
+		if req.Method == "org.example.test.Slow" {
+			<-release
+		}
+		return call.CloseWithReply(map[string]string{"method": req.Method})
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := c.DoContext(ctx, "org.example.test.Slow", nil, nil)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("DoContext() = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// The late reply to Slow must not be mistaken for the reply to Ping
+	close(release)
+	var out struct{ Method string }
+	if err := c.Do("org.example.test.Ping", nil, &out); err != nil {
+		t.Fatalf("Do() = %v", err)
+	} else if out.Method != "org.example.test.Ping" {
+		t.Errorf("Do() = %q, want reply for %q", out.Method, "org.example.test.Ping")
+	}
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := c.DoContext(canceled, "org.example.test.Ping", nil, nil); err != context.Canceled {
+		t.Errorf("DoContext() = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestClientCall_NextContext(t *testing.T) {
+	release := make(chan struct{})
+	c := newTestClient(t, handlerFunc(func(call *varlink.ServerCall, req *varlink.ServerRequest) error {
+		if err := call.Reply(map[string]int{"index": 0}); err != nil {
+			return err
+		}
+		<-release
+		return call.CloseWithReply(map[string]int{"index": 1})
+	}))
+
+	cc, err := c.DoMore("org.example.test.Monitor", nil)
+	if err != nil {
+		t.Fatalf("DoMore() = %v", err)
+	}
+
+	var out struct{ Index int }
+	if err := cc.NextContext(context.Background(), &out); err != nil {
+		t.Fatalf("NextContext() = %v", err)
+	} else if out.Index != 0 {
+		t.Errorf("NextContext() = %v, want 0", out.Index)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := cc.NextContext(ctx, &out); err != context.DeadlineExceeded {
+		t.Fatalf("NextContext() = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// The call is still usable after a timeout
+	close(release)
+	if err := cc.Next(&out); err != nil {
+		t.Fatalf("Next() = %v", err)
+	} else if out.Index != 1 {
+		t.Errorf("Next() = %v, want 1", out.Index)
+	}
+	if err := cc.Next(&out); err != io.EOF {
+		t.Errorf("Next() = %v, want io.EOF", err)
+	}
+}
+
+func TestCollectAll(t *testing.T) {
+	c := newTestClient(t, handlerFunc(func(call *varlink.ServerCall, req *varlink.ServerRequest) error {
+		for i := 0; i < 3; i++ {
+			if err := call.Reply(map[string]int{"i": i}); err != nil {
+				return err
+			}
+		}
+		if req.Method == "org.example.test.Fail" {
+			return &varlink.ServerError{Name: "org.example.test.Failed"}
+		}
+		return call.CloseWithReply(map[string]int{"i": 3})
+	}))
+
+	type item struct{ I int }
+	factory := func() interface{} { return new(item) }
+
+	for _, tc := range []struct {
+		method  string
+		n       int
+		wantErr bool
+	}{
+		{"org.example.test.Stream", 4, false},
+		{"org.example.test.Fail", 3, true},
+	} {
+		cc, err := c.DoMore(tc.method, nil)
+		if err != nil {
+			t.Fatalf("DoMore() = %v", err)
+		}
+		l, err := varlink.CollectAll(cc, factory)
+		if tc.wantErr != (err != nil) {
+			t.Errorf("%v: CollectAll() = %v, want error: %v", tc.method, err, tc.wantErr)
+		}
+		if len(l) != tc.n {
+			t.Fatalf("%v: CollectAll() returned %v values, want %v", tc.method, len(l), tc.n)
+		}
+		for i, v := range l {
+			if v.(*item).I != i {
+				t.Errorf("%v: CollectAll()[%v] = %v, want %v", tc.method, i, v.(*item).I, i)
+			}
+		}
+	}
+}
+
+func TestClient_Upgrade(t *testing.T) {
+	c := newTestClient(t, handlerFunc(func(call *varlink.ServerCall, req *varlink.ServerRequest) error {
+		switch req.Method {
+		case "org.example.test.Refuse":
+			return &varlink.ServerError{Name: "org.example.test.Refused"}
+		case "org.example.test.Echo":
+			conn, br, err := call.Upgrade(nil)
+			if err != nil {
+				return err
+			}
+			// Sent right after the reply, may be buffered by the client
+			conn.Write([]byte("welcome\n"))
+			go func() {
+				defer conn.Close()
+				line, err := br.ReadString('\n')
+				if err != nil {
+					return
+				}
+				conn.Write([]byte("echo: " + line))
+			}()
+			return nil
+		default:
+			return call.CloseWithReply(nil)
+		}
+	}))
+
+	if _, err := c.Upgrade("org.example.test.Refuse", nil); err == nil {
+		t.Fatalf("Upgrade(Refuse) = nil, want an error")
+	}
+	// The connection hasn't been upgraded
+	if err := c.Do("org.example.test.Ping", nil, nil); err != nil {
+		t.Fatalf("Do() = %v", err)
+	}
+
+	conn, err := c.Upgrade("org.example.test.Echo", nil)
+	if err != nil {
+		t.Fatalf("Upgrade() = %v", err)
+	}
+
+	br := bufio.NewReader(conn)
+	if line, err := br.ReadString('\n'); err != nil || line != "welcome\n" {
+		t.Errorf("ReadString() = %q, %v, want %q", line, err, "welcome\n")
+	}
+	if _, err := conn.Write([]byte("hello\n")); err != nil {
+		t.Fatalf("Write() = %v", err)
+	}
+	if line, err := br.ReadString('\n'); err != nil || line != "echo: hello\n" {
+		t.Errorf("ReadString() = %q, %v, want %q", line, err, "echo: hello\n")
+	}
+
+	if err := c.Do("org.example.test.Ping", nil, nil); err == nil {
+		t.Errorf("Do() after Upgrade = nil, want an error")
+	}
+}
@@ -0,0 +1,123 @@
+package ftl
+
+import (
+	"testing"
+
+	"github.com/emersion/go-varlink"
+	"github.com/emersion/go-varlink/varlinktest"
+)
+
+// TestClient_recording exercises the generated client against a fake
+// service.
+func TestClient_recording(t *testing.T) {
+	h := &varlinktest.RecordingHandler{
+		Replies: map[string][]varlinktest.Reply{
+			"org.example.ftl.CalculateConfiguration": {
+				{Parameters: &CalculateConfigurationOut{
+					Configuration: DriveConfiguration{Speed: 10, Trajectory: 20, Duration: 30},
+				}},
+			},
+			"org.example.ftl.Jump": {
+				{Error: &varlink.ServerError{
+					Name:       "org.example.ftl.ParameterOutOfRange",
+					Parameters: NewParameterOutOfRangeError("speed"),
+				}},
+			},
+		},
+	}
+
+	c := Client{varlinktest.NewClient(t, h)}
+
+	out, err := c.CalculateConfiguration(&CalculateConfigurationIn{
+		Current: Coordinate{Longitude: 1, Latitude: 2, Distance: 3},
+		Target:  Coordinate{Longitude: 4, Latitude: 5, Distance: 6},
+	})
+	if err != nil {
+		t.Fatalf("CalculateConfiguration() = %v", err)
+	} else if out.Configuration.Speed != 10 {
+		t.Errorf("CalculateConfiguration().Configuration.Speed = %v, want 10", out.Configuration.Speed)
+	}
+
+	_, err = c.Jump(&JumpIn{Configuration: out.Configuration})
+	if rangeErr, ok := err.(*ParameterOutOfRangeError); !ok || rangeErr.Field != "speed" {
+		t.Errorf("Jump() = %v, want a ParameterOutOfRangeError for speed", err)
+	}
+
+	calls := h.RecordedCalls()
+	if len(calls) != 2 || calls[0].Method != "org.example.ftl.CalculateConfiguration" || calls[1].Method != "org.example.ftl.Jump" {
+		t.Fatalf("RecordedCalls() = %v", calls)
+	}
+	const want = `{"current":{"distance":3,"latitude":2,"longitude":1},"target":{"distance":6,"latitude":5,"longitude":4}}`
+	if string(calls[0].Parameters) != want {
+		t.Errorf("CalculateConfiguration parameters = %s, want %s", calls[0].Parameters, want)
+	}
+}
+
+type backend struct{}
+
+func (backend) CalculateConfiguration(in *CalculateConfigurationIn) (*CalculateConfigurationOut, error) {
+	return &CalculateConfigurationOut{}, nil
+}
+
+func (backend) Jump(in *JumpIn) (*JumpOut, error) {
+	return &JumpOut{}, nil
+}
+
+func (backend) Monitor(in *MonitorIn) (*MonitorOut, error) {
+	return &MonitorOut{Condition: DriveCondition{State: DriveConditionStateIdle}}, nil
+}
+
+// TestHandler_server checks that the generated Handler can be used directly
+// as a Server handler, and rejects methods it doesn't implement.
+func TestHandler_server(t *testing.T) {
+	c := Client{varlinktest.NewClient(t, Handler{Backend: backend{}})}
+
+	if _, err := c.Jump(&JumpIn{}); err != nil {
+		t.Fatalf("Jump() = %v", err)
+	}
+
+	tests := []struct {
+		method, errName string
+	}{
+		{"org.example.ftl.Teleport", "org.varlink.service.MethodNotFound"},
+		{"org.example.other.Jump", "org.varlink.service.InterfaceNotFound"},
+	}
+	for _, tc := range tests {
+		err := c.Client.Do(tc.method, nil, nil)
+		if clientErr, ok := err.(*varlink.ClientError); !ok || clientErr.Name != tc.errName {
+			t.Errorf("Do(%q) = %v, want %v", tc.method, err, tc.errName)
+		}
+	}
+}
+
+// mockClient implements ClientInterface. Methods without streaming have the
+// same signature in Backend and ClientInterface, so UnimplementedBackend can
+// be embedded.
+type mockClient struct {
+	UnimplementedBackend
+}
+
+func (mockClient) CalculateConfiguration(in *CalculateConfigurationIn) (*CalculateConfigurationOut, error) {
+	return &CalculateConfigurationOut{Configuration: DriveConfiguration{Speed: in.Target.Distance}}, nil
+}
+
+func speedTo(c ClientInterface, target Coordinate) (int, error) {
+	out, err := c.CalculateConfiguration(&CalculateConfigurationIn{Target: target})
+	if err != nil {
+		return 0, err
+	}
+	return out.Configuration.Speed, nil
+}
+
+// TestClientInterface checks that both the generated Client and a mock can be
+// used through the generated ClientInterface.
+func TestClientInterface(t *testing.T) {
+	var _ ClientInterface = &Client{}
+
+	speed, err := speedTo(mockClient{}, Coordinate{Distance: 42})
+	if err != nil {
+		t.Fatalf("speedTo() = %v", err)
+	} else if speed != 42 {
+		t.Errorf("speedTo() = %v, want 42", speed)
+	}
+}
@@ -0,0 +1,353 @@
+// Code generated by go-varlink/varlinkgen. DO NOT EDIT.
+
+// Package ftl implements the org.example.ftl Varlink interface.
+//
+// Interface to jump a spacecraft to another point in space.
+// The FTL Drive is the propulsion system to achieve
+// faster-than-light travel through space. A ship making a
+// properly calculated jump can arrive safely in planetary
+// orbit, or alongside other ships or spaceborne objects.
+package ftl
+
+import (
+	"encoding/json"
+	"fmt"
+	govarlink "github.com/emersion/go-varlink"
+	"strings"
+)
+
+// The galactic coordinates use the Sun as the origin.
+// Galactic longitude is measured with primary direction
+// from the Sun to the center of the galaxy in the galactic
+// plane, while the galactic latitude measures the angle
+// of the object above the galactic plane.
+type Coordinate struct {
+	Distance  int     `json:"distance"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+func (v *Coordinate) Validate() error {
+	return nil
+}
+
+// The current state of the FTL drive and the amount of
+// fuel available to jump.
+type DriveCondition struct {
+	State       DriveConditionState `json:"state"`
+	TyliumLevel int                 `json:"tylium_level"`
+}
+
+func (v *DriveCondition) Validate() error {
+	switch v.State {
+	case "idle", "spooling", "busy":
+	default:
+		return fmt.Errorf("invalid value %q for field %q", v.State, "state")
+	}
+	return nil
+}
+
+type DriveConditionState string
+
+const (
+	DriveConditionStateIdle     DriveConditionState = "idle"
+	DriveConditionStateSpooling DriveConditionState = "spooling"
+	DriveConditionStateBusy     DriveConditionState = "busy"
+)
+
+func (v *DriveConditionState) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	switch DriveConditionState(s) {
+	case DriveConditionStateIdle, DriveConditionStateSpooling, DriveConditionStateBusy:
+	default:
+		return fmt.Errorf("invalid value %q for enum %q", s, "DriveConditionState")
+	}
+	*v = DriveConditionState(s)
+	return nil
+}
+
+// Speed, trajectory and jump duration is calculated prior
+// to activating the FTL drive.
+type DriveConfiguration struct {
+	Duration   int `json:"duration"`
+	Speed      int `json:"speed"`
+	Trajectory int `json:"trajectory"`
+}
+
+func (v *DriveConfiguration) Validate() error {
+	return nil
+}
+
+// There is not enough tylium to jump with the given
+// parameters
+type NotEnoughEnergyError struct{}
+
+func (err *NotEnoughEnergyError) Error() string {
+	return "varlink call failed: org.example.ftl.NotEnoughEnergy"
+}
+func NewNotEnoughEnergyError() *NotEnoughEnergyError {
+	return &NotEnoughEnergyError{}
+}
+
+// The supplied parameters are outside the supported range
+type ParameterOutOfRangeError struct {
+	Field string `json:"field"`
+}
+
+func (err *ParameterOutOfRangeError) Error() string {
+	return "varlink call failed: org.example.ftl.ParameterOutOfRange"
+}
+func NewParameterOutOfRangeError(field string) *ParameterOutOfRangeError {
+	return &ParameterOutOfRangeError{Field: field}
+}
+
+// Calculate the drive's jump parameters from the current
+// position to the target position in the galaxy
+type CalculateConfigurationIn struct {
+	Current Coordinate `json:"current"`
+	Target  Coordinate `json:"target"`
+}
+
+func (v *CalculateConfigurationIn) Validate() error {
+	if err := v.Current.Validate(); err != nil {
+		return fmt.Errorf("invalid field %q: %v", "current", err)
+	}
+	if err := v.Target.Validate(); err != nil {
+		return fmt.Errorf("invalid field %q: %v", "target", err)
+	}
+	return nil
+}
+
+// Calculate the drive's jump parameters from the current
+// position to the target position in the galaxy
+type CalculateConfigurationOut struct {
+	Configuration DriveConfiguration `json:"configuration"`
+}
+
+func (v *CalculateConfigurationOut) Validate() error {
+	if err := v.Configuration.Validate(); err != nil {
+		return fmt.Errorf("invalid field %q: %v", "configuration", err)
+	}
+	return nil
+}
+
+// Jump to the calculated point in space
+type JumpIn struct {
+	Configuration DriveConfiguration `json:"configuration"`
+}
+
+func (v *JumpIn) Validate() error {
+	if err := v.Configuration.Validate(); err != nil {
+		return fmt.Errorf("invalid field %q: %v", "configuration", err)
+	}
+	return nil
+}
+
+// Jump to the calculated point in space
+type JumpOut struct{}
+
+func (v *JumpOut) Validate() error {
+	return nil
+}
+
+// Monitor the drive. The method will reply with an update
+// whenever the drive's state changes
+type MonitorIn struct{}
+
+func (v *MonitorIn) Validate() error {
+	return nil
+}
+
+// Monitor the drive. The method will reply with an update
+// whenever the drive's state changes
+type MonitorOut struct {
+	Condition DriveCondition `json:"condition"`
+}
+
+func (v *MonitorOut) Validate() error {
+	if err := v.Condition.Validate(); err != nil {
+		return fmt.Errorf("invalid field %q: %v", "condition", err)
+	}
+	return nil
+}
+
+type Client struct {
+	*govarlink.Client
+}
+
+func unmarshalError(err error) error {
+	verr, ok := err.(*govarlink.ClientError)
+	if !ok {
+		return err
+	}
+	var v error
+	switch verr.Name {
+	case "org.example.ftl.NotEnoughEnergy":
+		v = new(NotEnoughEnergyError)
+	case "org.example.ftl.ParameterOutOfRange":
+		v = new(ParameterOutOfRangeError)
+	default:
+		return err
+	}
+	params := verr.Parameters
+	if len(params) == 0 {
+		params = json.RawMessage("{}")
+	}
+	if err := json.Unmarshal(params, v); err != nil {
+		return err
+	}
+	return v
+}
+
+// Calculate the drive's jump parameters from the current
+// position to the target position in the galaxy
+func (c Client) CalculateConfiguration(in *CalculateConfigurationIn) (*CalculateConfigurationOut, error) {
+	if in == nil {
+		in = new(CalculateConfigurationIn)
+	}
+	out := new(CalculateConfigurationOut)
+	err := c.Client.Do("org.example.ftl.CalculateConfiguration", in, out)
+	return out, unmarshalError(err)
+}
+
+// Jump to the calculated point in space
+func (c Client) Jump(in *JumpIn) (*JumpOut, error) {
+	if in == nil {
+		in = new(JumpIn)
+	}
+	out := new(JumpOut)
+	err := c.Client.Do("org.example.ftl.Jump", in, out)
+	return out, unmarshalError(err)
+}
+
+// Monitor the drive. The method will reply with an update
+// whenever the drive's state changes
+func (c Client) Monitor(in *MonitorIn) (*MonitorOut, error) {
+	if in == nil {
+		in = new(MonitorIn)
+	}
+	out := new(MonitorOut)
+	err := c.Client.Do("org.example.ftl.Monitor", in, out)
+	return out, unmarshalError(err)
+}
+
+type ClientInterface interface {
+	// Calculate the drive's jump parameters from the current
+	// position to the target position in the galaxy
+	CalculateConfiguration(*CalculateConfigurationIn) (*CalculateConfigurationOut, error)
+	// Jump to the calculated point in space
+	Jump(*JumpIn) (*JumpOut, error)
+	// Monitor the drive. The method will reply with an update
+	// whenever the drive's state changes
+	Monitor(*MonitorIn) (*MonitorOut, error)
+}
+
+var _ ClientInterface = (*Client)(nil)
+
+type Backend interface {
+	// Calculate the drive's jump parameters from the current
+	// position to the target position in the galaxy
+	CalculateConfiguration(*CalculateConfigurationIn) (*CalculateConfigurationOut, error)
+	// Jump to the calculated point in space
+	Jump(*JumpIn) (*JumpOut, error)
+	// Monitor the drive. The method will reply with an update
+	// whenever the drive's state changes
+	Monitor(*MonitorIn) (*MonitorOut, error)
+}
+
+type UnimplementedBackend struct{}
+
+var _ Backend = UnimplementedBackend{}
+
+func (UnimplementedBackend) CalculateConfiguration(*CalculateConfigurationIn) (*CalculateConfigurationOut, error) {
+	return nil, &govarlink.ServerError{
+		Name:       "org.varlink.service.MethodNotImplemented",
+		Parameters: map[string]string{"method": "org.example.ftl.CalculateConfiguration"},
+	}
+}
+func (UnimplementedBackend) Jump(*JumpIn) (*JumpOut, error) {
+	return nil, &govarlink.ServerError{
+		Name:       "org.varlink.service.MethodNotImplemented",
+		Parameters: map[string]string{"method": "org.example.ftl.Jump"},
+	}
+}
+func (UnimplementedBackend) Monitor(*MonitorIn) (*MonitorOut, error) {
+	return nil, &govarlink.ServerError{
+		Name:       "org.varlink.service.MethodNotImplemented",
+		Parameters: map[string]string{"method": "org.example.ftl.Monitor"},
+	}
+}
+
+// Interface to jump a spacecraft to another point in space.
+// The FTL Drive is the propulsion system to achieve
+// faster-than-light travel through space. A ship making a
+// properly calculated jump can arrive safely in planetary
+// orbit, or alongside other ships or spaceborne objects.
+type Handler struct {
+	Backend Backend
+}
+
+func marshalError(err error) error {
+	var name string
+	switch err.(type) {
+	case *NotEnoughEnergyError:
+		name = "org.example.ftl.NotEnoughEnergy"
+	case *ParameterOutOfRangeError:
+		name = "org.example.ftl.ParameterOutOfRange"
+	default:
+		return err
+	}
+	return &govarlink.ServerError{
+		Name:       name,
+		Parameters: err,
+	}
+}
+func (h Handler) HandleVarlink(call *govarlink.ServerCall, req *govarlink.ServerRequest) error {
+	var (
+		out interface{}
+		err error
+	)
+	switch req.Method {
+	case "org.example.ftl.CalculateConfiguration":
+		in := new(CalculateConfigurationIn)
+		if err := json.Unmarshal(req.Parameters, in); err != nil {
+			return err
+		}
+		out, err = h.Backend.CalculateConfiguration(in)
+	case "org.example.ftl.Jump":
+		in := new(JumpIn)
+		if err := json.Unmarshal(req.Parameters, in); err != nil {
+			return err
+		}
+		out, err = h.Backend.Jump(in)
+	case "org.example.ftl.Monitor":
+		in := new(MonitorIn)
+		if err := json.Unmarshal(req.Parameters, in); err != nil {
+			return err
+		}
+		out, err = h.Backend.Monitor(in)
+	default:
+		ifaceName := req.Method
+		if i := strings.LastIndexByte(ifaceName, '.'); i >= 0 {
+			ifaceName = ifaceName[:i]
+		}
+		if ifaceName == "org.example.ftl" {
+			err = &govarlink.ServerError{
+				Name:       "org.varlink.service.MethodNotFound",
+				Parameters: map[string]string{"method": req.Method},
+			}
+		} else {
+			err = &govarlink.ServerError{
+				Name:       "org.varlink.service.InterfaceNotFound",
+				Parameters: map[string]string{"interface": ifaceName},
+			}
+		}
+	}
+	if err != nil {
+		return marshalError(err)
+	}
+	return call.CloseWithReply(out)
+}
@@ -0,0 +1,286 @@
+package gentest
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-varlink"
+	"github.com/emersion/go-varlink/varlinktest"
+)
+
+// TestPaintIn_Validate checks values which can be unmarshaled but are invalid.
+// Invalid enum values are rejected when unmarshaling, see
+// TestColor_UnmarshalJSON and TestInlineEnum_UnmarshalJSON.
+func TestPaintIn_Validate(t *testing.T) {
+	tests := []struct {
+		name  string
+		raw   string
+		valid bool
+	}{
+		{"valid", `{"items": [{"name": "a", "color": "red", "shade": "dark"}], "palette": {"x": "blue"}, "extra": {}, "position": {"layer": "top"}}`, true},
+		{"missingArray", `{"palette": {}, "extra": {}, "position": {"layer": "top"}}`, false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var in PaintIn
+			if err := json.Unmarshal([]byte(tc.raw), &in); err != nil {
+				t.Fatalf("json.Unmarshal() = %v", err)
+			}
+			err := in.Validate()
+			if tc.valid && err != nil {
+				t.Errorf("Validate() = %v", err)
+			} else if !tc.valid && err == nil {
+				t.Errorf("Validate() = nil, want an error")
+			}
+		})
+	}
+}
+
+// TestPaintIn_Validate_enums checks that invalid enum values set from Go are
+// rejected.
+func TestPaintIn_Validate_enums(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(in *PaintIn)
+	}{
+		{"invalidNullableEnum", func(in *PaintIn) {
+			shade := ItemShade("medium")
+			in.Items = []Item{{Name: "a", Color: ColorRed, Shade: &shade}}
+		}},
+		{"invalidInlineEnum", func(in *PaintIn) { in.Position.Layer = "middle" }},
+		{"emptyEnum", func(in *PaintIn) { in.Position.Layer = "" }},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			in := PaintIn{
+				Items:   []Item{},
+				Palette: map[string]Color{},
+				Extra:   json.RawMessage("{}"),
+			}
+			in.Position.Layer = PaintInPositionLayerTop
+			if err := in.Validate(); err != nil {
+				t.Fatalf("Validate() = %v before modification", err)
+			}
+			tc.modify(&in)
+			if err := in.Validate(); err == nil {
+				t.Errorf("Validate() = nil, want an error")
+			}
+		})
+	}
+}
+
+// TestNullable checks that nullable fields are generated as pointers with
+// omitempty, and required fields as plain values, in both directions.
+func TestNullable(t *testing.T) {
+	tests := []struct {
+		v        interface{}
+		nullable map[string]bool
+	}{
+		{PaintIn{}, map[string]bool{
+			"DefaultColor": true,
+			"Extra":        false,
+			"Items":        false,
+			"Options":      true,
+			"Palette":      false,
+			"Position":     false,
+		}},
+		{PaintOut{}, map[string]bool{
+			"Note":    true,
+			"Painted": false,
+			"Skipped": true,
+			"Totals":  false,
+		}},
+	}
+
+	for _, tc := range tests {
+		typ := reflect.TypeOf(tc.v)
+		for i := 0; i < typ.NumField(); i++ {
+			field := typ.Field(i)
+			nullable, ok := tc.nullable[field.Name]
+			if !ok {
+				t.Errorf("%v: unexpected field %v", typ.Name(), field.Name)
+				continue
+			}
+			isPtr := field.Type.Kind() == reflect.Ptr
+			omitEmpty := strings.HasSuffix(field.Tag.Get("json"), ",omitempty")
+			if isPtr != nullable || omitEmpty != nullable {
+				t.Errorf("%v.%v: got type %v with tag %q, want nullable = %v", typ.Name(), field.Name, field.Type, field.Tag, nullable)
+			}
+		}
+	}
+
+	// Absent optional fields decode to nil, and are omitted when encoding
+	var out PaintOut
+	if err := json.Unmarshal([]byte(`{"painted": 1, "totals": {}}`), &out); err != nil {
+		t.Fatalf("json.Unmarshal() = %v", err)
+	}
+	if out.Note != nil || out.Skipped != nil {
+		t.Errorf("absent optional fields decoded to non-nil values: %+v", out)
+	}
+	b, err := json.Marshal(&out)
+	if err != nil {
+		t.Fatalf("json.Marshal() = %v", err)
+	}
+	if want := `{"painted":1,"totals":{}}`; string(b) != want {
+		t.Errorf("json.Marshal() = %v, want %v", string(b), want)
+	}
+}
+
+func TestErrorConstructors(t *testing.T) {
+	tests := []struct {
+		err    error
+		name   string
+		params string
+	}{
+		{NewBusyError(), "org.example.gentest.Busy", `{}`},
+		{NewInvalidColorError("pink"), "org.example.gentest.InvalidColor", `{"color":"pink"}`},
+	}
+
+	for _, tc := range tests {
+		serr, ok := marshalError(tc.err).(*varlink.ServerError)
+		if !ok {
+			t.Fatalf("marshalError(%T) = %v, want a *varlink.ServerError", tc.err, serr)
+		}
+		if serr.Name != tc.name {
+			t.Errorf("marshalError(%T).Name = %q, want %q", tc.err, serr.Name, tc.name)
+		}
+		params, err := json.Marshal(serr.Parameters)
+		if err != nil {
+			t.Fatalf("json.Marshal() = %v", err)
+		}
+		if string(params) != tc.params {
+			t.Errorf("marshalError(%T).Parameters = %s, want %s", tc.err, params, tc.params)
+		}
+
+		got := unmarshalError(&varlink.ClientError{Name: serr.Name, Parameters: params})
+		if !reflect.DeepEqual(got, tc.err) {
+			t.Errorf("unmarshalError() = %#v, want %#v", got, tc.err)
+		}
+	}
+}
+
+func TestUnmarshalError_noParameters(t *testing.T) {
+	err := unmarshalError(&varlink.ClientError{Name: "org.example.gentest.Busy"})
+	if _, ok := err.(*BusyError); !ok {
+		t.Errorf("unmarshalError() = %v, want *BusyError", err)
+	}
+}
+
+func TestColor_UnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		raw   string
+		want  Color
+		valid bool
+	}{
+		{`"red"`, ColorRed, true},
+		{`"blue"`, ColorBlue, true},
+		{`"pink"`, "", false},
+		{`""`, "", false},
+		{`"Red"`, "", false},
+		{`42`, "", false},
+	}
+
+	for _, tc := range tests {
+		var c Color
+		err := json.Unmarshal([]byte(tc.raw), &c)
+		if tc.valid && err != nil {
+			t.Errorf("json.Unmarshal(%v) = %v", tc.raw, err)
+		} else if !tc.valid && err == nil {
+			t.Errorf("json.Unmarshal(%v) = nil, want an error", tc.raw)
+		} else if c != tc.want {
+			t.Errorf("json.Unmarshal(%v) = %q, want %q", tc.raw, c, tc.want)
+		}
+
+		if tc.valid {
+			b, err := json.Marshal(c)
+			if err != nil {
+				t.Errorf("json.Marshal(%q) = %v", c, err)
+			} else if string(b) != tc.raw {
+				t.Errorf("json.Marshal(%q) = %s, want %s", c, b, tc.raw)
+			}
+		}
+	}
+
+	var in PaintIn
+	err := json.Unmarshal([]byte(`{"items": [], "palette": {"x": "pink"}}`), &in)
+	if err == nil || !strings.Contains(err.Error(), `"pink"`) {
+		t.Errorf("json.Unmarshal() = %v, want an error mentioning the invalid value", err)
+	}
+}
+
+func TestInlineEnum_UnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+	}{
+		{"invalidNullableEnum", `{"items": [{"name": "a", "color": "red", "shade": "medium"}], "palette": {}, "extra": {}, "position": {"layer": "top"}}`},
+		{"invalidInlineEnum", `{"items": [], "palette": {}, "extra": {}, "position": {"layer": "middle"}}`},
+		{"emptyEnum", `{"items": [], "palette": {}, "extra": {}, "position": {"layer": ""}}`},
+	}
+
+	for _, tc := range tests {
+		var in PaintIn
+		if err := json.Unmarshal([]byte(tc.raw), &in); err == nil {
+			t.Errorf("%v: json.Unmarshal() = nil, want an error", tc.name)
+		}
+	}
+
+	var in PaintIn
+	raw := `{"items": [{"name": "a", "color": "red", "shade": "dark"}], "palette": {}, "extra": {}, "position": {"layer": "bottom"}}`
+	if err := json.Unmarshal([]byte(raw), &in); err != nil {
+		t.Fatalf("json.Unmarshal() = %v", err)
+	}
+	if in.Position.Layer != PaintInPositionLayerBottom {
+		t.Errorf("Position.Layer = %q, want %q", in.Position.Layer, PaintInPositionLayerBottom)
+	}
+	if in.Items[0].Shade == nil || *in.Items[0].Shade != ItemShadeDark {
+		t.Errorf("Items[0].Shade = %v, want %q", in.Items[0].Shade, ItemShadeDark)
+	}
+}
+
+type errorBackend struct {
+	err error
+}
+
+func (be errorBackend) Paint(in *PaintIn) (*PaintOut, error) {
+	return nil, be.err
+}
+
+// TestClient_errors checks that errors returned by a Backend reach the Client
+// as the generated error types.
+func TestClient_errors(t *testing.T) {
+	be := &errorBackend{}
+	c := Client{varlinktest.NewClient(t, Handler{Backend: be})}
+
+	in := &PaintIn{Items: []Item{}, Palette: map[string]Color{}, Extra: json.RawMessage("{}")}
+	in.Position.Layer = PaintInPositionLayerTop
+
+	be.err = NewInvalidColorError("pink")
+	_, err := c.Paint(in)
+	var invalidColor *InvalidColorError
+	if !errors.As(err, &invalidColor) {
+		t.Errorf("Paint() = %v, want an *InvalidColorError", err)
+	} else if invalidColor.Color != "pink" {
+		t.Errorf("InvalidColorError.Color = %q, want %q", invalidColor.Color, "pink")
+	}
+
+	be.err = NewBusyError()
+	_, err = c.Paint(in)
+	var busy *BusyError
+	if !errors.As(err, &busy) {
+		t.Errorf("Paint() = %v, want a *BusyError", err)
+	}
+
+	// Errors not defined in the interface are returned as-is
+	be.err = &varlink.ServerError{Name: "org.example.other.Failed"}
+	_, err = c.Paint(in)
+	var clientErr *varlink.ClientError
+	if !errors.As(err, &clientErr) || clientErr.Name != "org.example.other.Failed" {
+		t.Errorf("Paint() = %v, want a *varlink.ClientError", err)
+	}
+}
@@ -0,0 +1,302 @@
+// Code generated by go-varlink/varlinkgen. DO NOT EDIT.
+
+// Package gentest implements the org.example.gentest Varlink interface.
+//
+// Interface exercising varlinkgen features.
+package gentest
+
+import (
+	"encoding/json"
+	"fmt"
+	govarlink "github.com/emersion/go-varlink"
+	"strings"
+)
+
+type Color string
+
+const (
+	ColorRed   Color = "red"
+	ColorGreen Color = "green"
+	ColorBlue  Color = "blue"
+)
+
+func (v *Color) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	switch Color(s) {
+	case ColorRed, ColorGreen, ColorBlue:
+	default:
+		return fmt.Errorf("invalid value %q for enum %q", s, "Color")
+	}
+	*v = Color(s)
+	return nil
+}
+
+type Item struct {
+	Color Color      `json:"color"`
+	Name  string     `json:"name"`
+	Shade *ItemShade `json:"shade,omitempty"`
+}
+
+func (v *Item) Validate() error {
+	switch v.Color {
+	case "red", "green", "blue":
+	default:
+		return fmt.Errorf("invalid value %q for field %q", v.Color, "color")
+	}
+	if v.Shade != nil {
+		switch *v.Shade {
+		case "light", "dark":
+		default:
+			return fmt.Errorf("invalid value %q for field %q", *v.Shade, "shade")
+		}
+	}
+	return nil
+}
+
+type ItemShade string
+
+const (
+	ItemShadeLight ItemShade = "light"
+	ItemShadeDark  ItemShade = "dark"
+)
+
+func (v *ItemShade) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	switch ItemShade(s) {
+	case ItemShadeLight, ItemShadeDark:
+	default:
+		return fmt.Errorf("invalid value %q for enum %q", s, "ItemShade")
+	}
+	*v = ItemShade(s)
+	return nil
+}
+
+type BusyError struct{}
+
+func (err *BusyError) Error() string {
+	return "varlink call failed: org.example.gentest.Busy"
+}
+func NewBusyError() *BusyError {
+	return &BusyError{}
+}
+
+type InvalidColorError struct {
+	Color string `json:"color"`
+}
+
+func (err *InvalidColorError) Error() string {
+	return "varlink call failed: org.example.gentest.InvalidColor"
+}
+func NewInvalidColorError(color string) *InvalidColorError {
+	return &InvalidColorError{Color: color}
+}
+
+type PaintIn struct {
+	DefaultColor *Color           `json:"default_color,omitempty"`
+	Extra        json.RawMessage  `json:"extra"`
+	Items        []Item           `json:"items"`
+	Options      *[]string        `json:"options,omitempty"`
+	Palette      map[string]Color `json:"palette"`
+	Position     struct {
+		Layer PaintInPositionLayer `json:"layer"`
+		X     int                  `json:"x"`
+		Y     int                  `json:"y"`
+	} `json:"position"`
+}
+
+func (v *PaintIn) Validate() error {
+	if v.DefaultColor != nil {
+		switch *v.DefaultColor {
+		case "red", "green", "blue":
+		default:
+			return fmt.Errorf("invalid value %q for field %q", *v.DefaultColor, "default_color")
+		}
+	}
+	if v.Extra == nil {
+		return fmt.Errorf("missing field %q", "extra")
+	}
+	if v.Items == nil {
+		return fmt.Errorf("missing field %q", "items")
+	}
+	for _, v1 := range v.Items {
+		if err := v1.Validate(); err != nil {
+			return fmt.Errorf("invalid field %q: %v", "items[]", err)
+		}
+	}
+	if v.Palette == nil {
+		return fmt.Errorf("missing field %q", "palette")
+	}
+	for _, v1 := range v.Palette {
+		switch v1 {
+		case "red", "green", "blue":
+		default:
+			return fmt.Errorf("invalid value %q for field %q", v1, "palette[]")
+		}
+	}
+	switch v.Position.Layer {
+	case "top", "bottom":
+	default:
+		return fmt.Errorf("invalid value %q for field %q", v.Position.Layer, "position.layer")
+	}
+	return nil
+}
+
+type PaintInPositionLayer string
+
+const (
+	PaintInPositionLayerTop    PaintInPositionLayer = "top"
+	PaintInPositionLayerBottom PaintInPositionLayer = "bottom"
+)
+
+func (v *PaintInPositionLayer) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	switch PaintInPositionLayer(s) {
+	case PaintInPositionLayerTop, PaintInPositionLayerBottom:
+	default:
+		return fmt.Errorf("invalid value %q for enum %q", s, "PaintInPositionLayer")
+	}
+	*v = PaintInPositionLayer(s)
+	return nil
+}
+
+type PaintOut struct {
+	Note    *string        `json:"note,omitempty"`
+	Painted int            `json:"painted"`
+	Skipped *[]Item        `json:"skipped,omitempty"`
+	Totals  map[string]int `json:"totals"`
+}
+
+func (v *PaintOut) Validate() error {
+	if v.Skipped != nil {
+		for _, v1 := range *v.Skipped {
+			if err := v1.Validate(); err != nil {
+				return fmt.Errorf("invalid field %q: %v", "skipped[]", err)
+			}
+		}
+	}
+	if v.Totals == nil {
+		return fmt.Errorf("missing field %q", "totals")
+	}
+	return nil
+}
+
+type Client struct {
+	*govarlink.Client
+}
+
+func unmarshalError(err error) error {
+	verr, ok := err.(*govarlink.ClientError)
+	if !ok {
+		return err
+	}
+	var v error
+	switch verr.Name {
+	case "org.example.gentest.Busy":
+		v = new(BusyError)
+	case "org.example.gentest.InvalidColor":
+		v = new(InvalidColorError)
+	default:
+		return err
+	}
+	params := verr.Parameters
+	if len(params) == 0 {
+		params = json.RawMessage("{}")
+	}
+	if err := json.Unmarshal(params, v); err != nil {
+		return err
+	}
+	return v
+}
+func (c Client) Paint(in *PaintIn) (*PaintOut, error) {
+	if in == nil {
+		in = new(PaintIn)
+	}
+	out := new(PaintOut)
+	err := c.Client.Do("org.example.gentest.Paint", in, out)
+	return out, unmarshalError(err)
+}
+
+type ClientInterface interface {
+	Paint(*PaintIn) (*PaintOut, error)
+}
+
+var _ ClientInterface = (*Client)(nil)
+
+type Backend interface {
+	Paint(*PaintIn) (*PaintOut, error)
+}
+
+type UnimplementedBackend struct{}
+
+var _ Backend = UnimplementedBackend{}
+
+func (UnimplementedBackend) Paint(*PaintIn) (*PaintOut, error) {
+	return nil, &govarlink.ServerError{
+		Name:       "org.varlink.service.MethodNotImplemented",
+		Parameters: map[string]string{"method": "org.example.gentest.Paint"},
+	}
+}
+
+// Interface exercising varlinkgen features.
+type Handler struct {
+	Backend Backend
+}
+
+func marshalError(err error) error {
+	var name string
+	switch err.(type) {
+	case *BusyError:
+		name = "org.example.gentest.Busy"
+	case *InvalidColorError:
+		name = "org.example.gentest.InvalidColor"
+	default:
+		return err
+	}
+	return &govarlink.ServerError{
+		Name:       name,
+		Parameters: err,
+	}
+}
+func (h Handler) HandleVarlink(call *govarlink.ServerCall, req *govarlink.ServerRequest) error {
+	var (
+		out interface{}
+		err error
+	)
+	switch req.Method {
+	case "org.example.gentest.Paint":
+		in := new(PaintIn)
+		if err := json.Unmarshal(req.Parameters, in); err != nil {
+			return err
+		}
+		out, err = h.Backend.Paint(in)
+	default:
+		ifaceName := req.Method
+		if i := strings.LastIndexByte(ifaceName, '.'); i >= 0 {
+			ifaceName = ifaceName[:i]
+		}
+		if ifaceName == "org.example.gentest" {
+			err = &govarlink.ServerError{
+				Name:       "org.varlink.service.MethodNotFound",
+				Parameters: map[string]string{"method": req.Method},
+			}
+		} else {
+			err = &govarlink.ServerError{
+				Name:       "org.varlink.service.InterfaceNotFound",
+				Parameters: map[string]string{"interface": ifaceName},
+			}
+		}
+	}
+	if err != nil {
+		return marshalError(err)
+	}
+	return call.CloseWithReply(out)
+}
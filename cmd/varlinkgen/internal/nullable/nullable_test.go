@@ -0,0 +1,59 @@
+package nullable
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestGetOut_absent(t *testing.T) {
+	var out GetOut
+	raw := `{"text": null, "holes": []}`
+	if err := json.Unmarshal([]byte(raw), &out); err != nil {
+		t.Fatalf("json.Unmarshal() = %v", err)
+	}
+	if out.Text != nil || out.Count != nil || out.Ratio != nil || out.Enabled != nil ||
+		out.Point != nil || out.Level != nil || out.Mode != nil || out.Inline != nil ||
+		out.Tags != nil || out.Labels != nil || out.Extra != nil {
+		t.Errorf("absent or null fields decoded to non-nil values: %+v", out)
+	}
+	if err := out.Validate(); err != nil {
+		t.Errorf("Validate() = %v", err)
+	}
+
+	b, err := json.Marshal(&out)
+	if err != nil {
+		t.Fatalf("json.Marshal() = %v", err)
+	}
+	if want := `{"holes":[]}`; string(b) != want {
+		t.Errorf("json.Marshal() = %s, want %s", b, want)
+	}
+}
+
+func TestGetOut_zero(t *testing.T) {
+	// Zero values are distinguished from absent values
+	const raw = `{"count":0,"enabled":false,"extra":{},"holes":[null,0],"inline":{"a":0},"labels":{},"level":"low","mode":"fast","point":{"x":0,"y":0},"ratio":0,"tags":[],"text":""}`
+
+	var out GetOut
+	if err := json.Unmarshal([]byte(raw), &out); err != nil {
+		t.Fatalf("json.Unmarshal() = %v", err)
+	}
+	if out.Text == nil || out.Count == nil || out.Ratio == nil || out.Enabled == nil ||
+		out.Point == nil || out.Level == nil || out.Mode == nil || out.Inline == nil ||
+		out.Tags == nil || out.Labels == nil || out.Extra == nil {
+		t.Errorf("zero fields decoded to nil values: %+v", out)
+	}
+	if len(out.Holes) != 2 || out.Holes[0] != nil || out.Holes[1] == nil {
+		t.Errorf("Holes = %v, want [nil, 0]", out.Holes)
+	}
+	if err := out.Validate(); err != nil {
+		t.Errorf("Validate() = %v", err)
+	}
+
+	b, err := json.Marshal(&out)
+	if err != nil {
+		t.Fatalf("json.Marshal() = %v", err)
+	}
+	if string(b) != raw {
+		t.Errorf("json.Marshal() = %s, want %s", b, raw)
+	}
+}
@@ -0,0 +1,193 @@
+// Code generated by go-varlink/varlinkgen. DO NOT EDIT.
+
+// Package nullable implements the org.example.nullable Varlink interface.
+//
+// Interface exercising nullable types.
+package nullable
+
+import (
+	"encoding/json"
+	"fmt"
+	govarlink "github.com/emersion/go-varlink"
+	"strings"
+)
+
+type Level string
+
+const (
+	LevelLow  Level = "low"
+	LevelHigh Level = "high"
+)
+
+func (v *Level) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	switch Level(s) {
+	case LevelLow, LevelHigh:
+	default:
+		return fmt.Errorf("invalid value %q for enum %q", s, "Level")
+	}
+	*v = Level(s)
+	return nil
+}
+
+type Point struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+func (v *Point) Validate() error {
+	return nil
+}
+
+type GetIn struct{}
+
+func (v *GetIn) Validate() error {
+	return nil
+}
+
+type GetOut struct {
+	Count   *int             `json:"count,omitempty"`
+	Enabled *bool            `json:"enabled,omitempty"`
+	Extra   *json.RawMessage `json:"extra,omitempty"`
+	Holes   []*int           `json:"holes"`
+	Inline  *struct {
+		A int `json:"a"`
+	} `json:"inline,omitempty"`
+	Labels *map[string]string `json:"labels,omitempty"`
+	Level  *Level             `json:"level,omitempty"`
+	Mode   *GetOutMode        `json:"mode,omitempty"`
+	Point  *Point             `json:"point,omitempty"`
+	Ratio  *float64           `json:"ratio,omitempty"`
+	Tags   *[]string          `json:"tags,omitempty"`
+	Text   *string            `json:"text,omitempty"`
+}
+
+func (v *GetOut) Validate() error {
+	if v.Holes == nil {
+		return fmt.Errorf("missing field %q", "holes")
+	}
+	if v.Level != nil {
+		switch *v.Level {
+		case "low", "high":
+		default:
+			return fmt.Errorf("invalid value %q for field %q", *v.Level, "level")
+		}
+	}
+	if v.Mode != nil {
+		switch *v.Mode {
+		case "fast", "slow":
+		default:
+			return fmt.Errorf("invalid value %q for field %q", *v.Mode, "mode")
+		}
+	}
+	if v.Point != nil {
+		if err := v.Point.Validate(); err != nil {
+			return fmt.Errorf("invalid field %q: %v", "point", err)
+		}
+	}
+	return nil
+}
+
+type GetOutMode string
+
+const (
+	GetOutModeFast GetOutMode = "fast"
+	GetOutModeSlow GetOutMode = "slow"
+)
+
+func (v *GetOutMode) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	switch GetOutMode(s) {
+	case GetOutModeFast, GetOutModeSlow:
+	default:
+		return fmt.Errorf("invalid value %q for enum %q", s, "GetOutMode")
+	}
+	*v = GetOutMode(s)
+	return nil
+}
+
+type Client struct {
+	*govarlink.Client
+}
+
+func unmarshalError(err error) error {
+	return err
+}
+func (c Client) Get(in *GetIn) (*GetOut, error) {
+	if in == nil {
+		in = new(GetIn)
+	}
+	out := new(GetOut)
+	err := c.Client.Do("org.example.nullable.Get", in, out)
+	return out, unmarshalError(err)
+}
+
+type ClientInterface interface {
+	Get(*GetIn) (*GetOut, error)
+}
+
+var _ ClientInterface = (*Client)(nil)
+
+type Backend interface {
+	Get(*GetIn) (*GetOut, error)
+}
+
+type UnimplementedBackend struct{}
+
+var _ Backend = UnimplementedBackend{}
+
+func (UnimplementedBackend) Get(*GetIn) (*GetOut, error) {
+	return nil, &govarlink.ServerError{
+		Name:       "org.varlink.service.MethodNotImplemented",
+		Parameters: map[string]string{"method": "org.example.nullable.Get"},
+	}
+}
+
+// Interface exercising nullable types.
+type Handler struct {
+	Backend Backend
+}
+
+func marshalError(err error) error {
+	return err
+}
+func (h Handler) HandleVarlink(call *govarlink.ServerCall, req *govarlink.ServerRequest) error {
+	var (
+		out interface{}
+		err error
+	)
+	switch req.Method {
+	case "org.example.nullable.Get":
+		in := new(GetIn)
+		if err := json.Unmarshal(req.Parameters, in); err != nil {
+			return err
+		}
+		out, err = h.Backend.Get(in)
+	default:
+		ifaceName := req.Method
+		if i := strings.LastIndexByte(ifaceName, '.'); i >= 0 {
+			ifaceName = ifaceName[:i]
+		}
+		if ifaceName == "org.example.nullable" {
+			err = &govarlink.ServerError{
+				Name:       "org.varlink.service.MethodNotFound",
+				Parameters: map[string]string{"method": req.Method},
+			}
+		} else {
+			err = &govarlink.ServerError{
+				Name:       "org.varlink.service.InterfaceNotFound",
+				Parameters: map[string]string{"interface": ifaceName},
+			}
+		}
+	}
+	if err != nil {
+		return marshalError(err)
+	}
+	return call.CloseWithReply(out)
+}
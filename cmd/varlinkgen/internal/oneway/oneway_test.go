@@ -0,0 +1,42 @@
+package oneway
+
+import (
+	"testing"
+
+	"github.com/emersion/go-varlink/varlinktest"
+)
+
+type backend struct {
+	messages chan string
+}
+
+func (be *backend) Notify(in *NotifyIn) (*NotifyOut, error) {
+	be.messages <- in.Message
+	return &NotifyOut{}, nil
+}
+
+func (be *backend) Ping(in *PingIn) (*PingOut, error) {
+	return &PingOut{}, nil
+}
+
+func TestClient_NotifyOneway(t *testing.T) {
+	be := &backend{messages: make(chan string, 1)}
+	c := Client{varlinktest.NewClient(t, Handler{Backend: be})}
+
+	if err := c.NotifyOneway(&NotifyIn{Message: "hello"}); err != nil {
+		t.Fatalf("NotifyOneway() = %v", err)
+	}
+	// Requests are handled in order and no reply is sent for Notify: if
+	// one was, Ping would receive it
+	if _, err := c.Ping(nil); err != nil {
+		t.Fatalf("Ping() = %v", err)
+	}
+	select {
+	case msg := <-be.messages:
+		if msg != "hello" {
+			t.Errorf("Notify received %q, want %q", msg, "hello")
+		}
+	default:
+		t.Errorf("Notify not called")
+	}
+}
@@ -0,0 +1,159 @@
+// Code generated by go-varlink/varlinkgen. DO NOT EDIT.
+
+// Package oneway implements the org.example.oneway Varlink interface.
+//
+// Interface exercising oneway client wrappers.
+package oneway
+
+import (
+	"encoding/json"
+	govarlink "github.com/emersion/go-varlink"
+	"strings"
+)
+
+// Generated with a oneway wrapper.
+type NotifyIn struct {
+	Message string `json:"message"`
+}
+
+func (v *NotifyIn) Validate() error {
+	return nil
+}
+
+// Generated with a oneway wrapper.
+type NotifyOut struct{}
+
+func (v *NotifyOut) Validate() error {
+	return nil
+}
+
+// Generated without a oneway wrapper.
+type PingIn struct{}
+
+func (v *PingIn) Validate() error {
+	return nil
+}
+
+// Generated without a oneway wrapper.
+type PingOut struct{}
+
+func (v *PingOut) Validate() error {
+	return nil
+}
+
+type Client struct {
+	*govarlink.Client
+}
+
+func unmarshalError(err error) error {
+	return err
+}
+
+// Generated with a oneway wrapper.
+func (c Client) Notify(in *NotifyIn) (*NotifyOut, error) {
+	if in == nil {
+		in = new(NotifyIn)
+	}
+	out := new(NotifyOut)
+	err := c.Client.Do("org.example.oneway.Notify", in, out)
+	return out, unmarshalError(err)
+}
+func (c Client) NotifyOneway(in *NotifyIn) error {
+	if in == nil {
+		in = new(NotifyIn)
+	}
+	return c.Client.DoOneway("org.example.oneway.Notify", in)
+}
+
+// Generated without a oneway wrapper.
+func (c Client) Ping(in *PingIn) (*PingOut, error) {
+	if in == nil {
+		in = new(PingIn)
+	}
+	out := new(PingOut)
+	err := c.Client.Do("org.example.oneway.Ping", in, out)
+	return out, unmarshalError(err)
+}
+
+type ClientInterface interface {
+	// Generated with a oneway wrapper.
+	Notify(*NotifyIn) (*NotifyOut, error)
+	NotifyOneway(*NotifyIn) error
+	// Generated without a oneway wrapper.
+	Ping(*PingIn) (*PingOut, error)
+}
+
+var _ ClientInterface = (*Client)(nil)
+
+type Backend interface {
+	// Generated with a oneway wrapper.
+	Notify(*NotifyIn) (*NotifyOut, error)
+	// Generated without a oneway wrapper.
+	Ping(*PingIn) (*PingOut, error)
+}
+
+type UnimplementedBackend struct{}
+
+var _ Backend = UnimplementedBackend{}
+
+func (UnimplementedBackend) Notify(*NotifyIn) (*NotifyOut, error) {
+	return nil, &govarlink.ServerError{
+		Name:       "org.varlink.service.MethodNotImplemented",
+		Parameters: map[string]string{"method": "org.example.oneway.Notify"},
+	}
+}
+func (UnimplementedBackend) Ping(*PingIn) (*PingOut, error) {
+	return nil, &govarlink.ServerError{
+		Name:       "org.varlink.service.MethodNotImplemented",
+		Parameters: map[string]string{"method": "org.example.oneway.Ping"},
+	}
+}
+
+// Interface exercising oneway client wrappers.
+type Handler struct {
+	Backend Backend
+}
+
+func marshalError(err error) error {
+	return err
+}
+func (h Handler) HandleVarlink(call *govarlink.ServerCall, req *govarlink.ServerRequest) error {
+	var (
+		out interface{}
+		err error
+	)
+	switch req.Method {
+	case "org.example.oneway.Notify":
+		in := new(NotifyIn)
+		if err := json.Unmarshal(req.Parameters, in); err != nil {
+			return err
+		}
+		out, err = h.Backend.Notify(in)
+	case "org.example.oneway.Ping":
+		in := new(PingIn)
+		if err := json.Unmarshal(req.Parameters, in); err != nil {
+			return err
+		}
+		out, err = h.Backend.Ping(in)
+	default:
+		ifaceName := req.Method
+		if i := strings.LastIndexByte(ifaceName, '.'); i >= 0 {
+			ifaceName = ifaceName[:i]
+		}
+		if ifaceName == "org.example.oneway" {
+			err = &govarlink.ServerError{
+				Name:       "org.varlink.service.MethodNotFound",
+				Parameters: map[string]string{"method": req.Method},
+			}
+		} else {
+			err = &govarlink.ServerError{
+				Name:       "org.varlink.service.InterfaceNotFound",
+				Parameters: map[string]string{"interface": ifaceName},
+			}
+		}
+	}
+	if err != nil {
+		return marshalError(err)
+	}
+	return call.CloseWithReply(out)
+}
@@ -0,0 +1,113 @@
+// Code generated by go-varlink/varlinkgen. DO NOT EDIT.
+
+// Package peer implements the org.example.peer Varlink interface.
+//
+// Interface exercising the call context passed to backends.
+package peer
+
+import (
+	"context"
+	"encoding/json"
+	govarlink "github.com/emersion/go-varlink"
+	"strings"
+)
+
+// Returns the user ID of the caller.
+type WhoAmIIn struct{}
+
+func (v *WhoAmIIn) Validate() error {
+	return nil
+}
+
+// Returns the user ID of the caller.
+type WhoAmIOut struct {
+	UID int `json:"uid"`
+}
+
+func (v *WhoAmIOut) Validate() error {
+	return nil
+}
+
+type Client struct {
+	*govarlink.Client
+}
+
+func unmarshalError(err error) error {
+	return err
+}
+
+// Returns the user ID of the caller.
+func (c Client) WhoAmI(in *WhoAmIIn) (*WhoAmIOut, error) {
+	if in == nil {
+		in = new(WhoAmIIn)
+	}
+	out := new(WhoAmIOut)
+	err := c.Client.Do("org.example.peer.WhoAmI", in, out)
+	return out, unmarshalError(err)
+}
+
+type ClientInterface interface {
+	// Returns the user ID of the caller.
+	WhoAmI(*WhoAmIIn) (*WhoAmIOut, error)
+}
+
+var _ ClientInterface = (*Client)(nil)
+
+type Backend interface {
+	// Returns the user ID of the caller.
+	WhoAmI(context.Context, *WhoAmIIn) (*WhoAmIOut, error)
+}
+
+type UnimplementedBackend struct{}
+
+var _ Backend = UnimplementedBackend{}
+
+func (UnimplementedBackend) WhoAmI(context.Context, *WhoAmIIn) (*WhoAmIOut, error) {
+	return nil, &govarlink.ServerError{
+		Name:       "org.varlink.service.MethodNotImplemented",
+		Parameters: map[string]string{"method": "org.example.peer.WhoAmI"},
+	}
+}
+
+// Interface exercising the call context passed to backends.
+type Handler struct {
+	Backend Backend
+}
+
+func marshalError(err error) error {
+	return err
+}
+func (h Handler) HandleVarlink(call *govarlink.ServerCall, req *govarlink.ServerRequest) error {
+	var (
+		out interface{}
+		err error
+	)
+	switch req.Method {
+	case "org.example.peer.WhoAmI":
+		in := new(WhoAmIIn)
+		if err := json.Unmarshal(req.Parameters, in); err != nil {
+			return err
+		}
+		out, err = h.Backend.WhoAmI(call.Context(), in)
+	default:
+		ifaceName := req.Method
+		if i := strings.LastIndexByte(ifaceName, '.'); i >= 0 {
+			ifaceName = ifaceName[:i]
+		}
+		if ifaceName == "org.example.peer" {
+			err = &govarlink.ServerError{
+				Name:       "org.varlink.service.MethodNotFound",
+				Parameters: map[string]string{"method": req.Method},
+			}
+		} else {
+			err = &govarlink.ServerError{
+				Name:       "org.varlink.service.InterfaceNotFound",
+				Parameters: map[string]string{"interface": ifaceName},
+			}
+		}
+	}
+	if err != nil {
+		return marshalError(err)
+	}
+	return call.CloseWithReply(out)
+}
@@ -0,0 +1,47 @@
+package peer
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/emersion/go-varlink"
+)
+
+type backend struct{}
+
+func (backend) WhoAmI(ctx context.Context, in *WhoAmIIn) (*WhoAmIOut, error) {
+	cred, ok := varlink.PeerFromContext(ctx)
+	if !ok {
+		return nil, fmt.Errorf("missing peer credentials")
+	}
+	return &WhoAmIOut{UID: int(cred.UID)}, nil
+}
+
+func TestHandler_peerFromContext(t *testing.T) {
+	ln, err := net.Listen("unix", filepath.Join(t.TempDir(), "test.sock"))
+	if err != nil {
+		t.Fatalf("net.Listen() = %v", err)
+	}
+	defer ln.Close()
+
+	srv := varlink.NewServer()
+	srv.Handler = Handler{Backend: backend{}}
+	go srv.Serve(ln)
+
+	c, err := varlink.Dial("unix:" + ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial() = %v", err)
+	}
+	defer c.Close()
+
+	out, err := Client{c}.WhoAmI(nil)
+	if err != nil {
+		t.Fatalf("WhoAmI() = %v", err)
+	} else if out.UID != os.Getuid() {
+		t.Errorf("WhoAmI() = %v, want %v", out.UID, os.Getuid())
+	}
+}
@@ -0,0 +1,221 @@
+// Code generated by go-varlink/varlinkgen. DO NOT EDIT.
+
+// Package stream implements the org.example.stream Varlink interface.
+//
+// Interface exercising methods returning multiple replies.
+package stream
+
+import (
+	"encoding/json"
+	govarlink "github.com/emersion/go-varlink"
+	"strings"
+)
+
+type InterruptedError struct {
+	At int `json:"at"`
+}
+
+func (err *InterruptedError) Error() string {
+	return "varlink call failed: org.example.stream.Interrupted"
+}
+func NewInterruptedError(at int) *InterruptedError {
+	return &InterruptedError{At: at}
+}
+
+// Generated with a regular wrapper.
+type GetIn struct{}
+
+func (v *GetIn) Validate() error {
+	return nil
+}
+
+// Generated with a regular wrapper.
+type GetOut struct {
+	Index int `json:"index"`
+}
+
+func (v *GetOut) Validate() error {
+	return nil
+}
+
+// Generated with streaming client and server wrappers.
+type MonitorIn struct {
+	Count int `json:"count"`
+}
+
+func (v *MonitorIn) Validate() error {
+	return nil
+}
+
+// Generated with streaming client and server wrappers.
+type MonitorOut struct {
+	Index int `json:"index"`
+}
+
+func (v *MonitorOut) Validate() error {
+	return nil
+}
+
+type Client struct {
+	*govarlink.Client
+}
+
+func unmarshalError(err error) error {
+	verr, ok := err.(*govarlink.ClientError)
+	if !ok {
+		return err
+	}
+	var v error
+	switch verr.Name {
+	case "org.example.stream.Interrupted":
+		v = new(InterruptedError)
+	default:
+		return err
+	}
+	params := verr.Parameters
+	if len(params) == 0 {
+		params = json.RawMessage("{}")
+	}
+	if err := json.Unmarshal(params, v); err != nil {
+		return err
+	}
+	return v
+}
+
+// Generated with a regular wrapper.
+func (c Client) Get(in *GetIn) (*GetOut, error) {
+	if in == nil {
+		in = new(GetIn)
+	}
+	out := new(GetOut)
+	err := c.Client.Do("org.example.stream.Get", in, out)
+	return out, unmarshalError(err)
+}
+
+type MonitorStream struct {
+	call *govarlink.ClientCall
+}
+
+func (s *MonitorStream) Next() (*MonitorOut, error) {
+	out := new(MonitorOut)
+	if err := s.call.Next(out); err != nil {
+		return nil, unmarshalError(err)
+	}
+	return out, nil
+}
+
+// Generated with streaming client and server wrappers.
+func (c Client) Monitor(in *MonitorIn) (*MonitorStream, error) {
+	if in == nil {
+		in = new(MonitorIn)
+	}
+	call, err := c.Client.DoMore("org.example.stream.Monitor", in)
+	if err != nil {
+		return nil, err
+	}
+	return &MonitorStream{call}, nil
+}
+
+type ClientInterface interface {
+	// Generated with a regular wrapper.
+	Get(*GetIn) (*GetOut, error)
+	// Generated with streaming client and server wrappers.
+	Monitor(*MonitorIn) (*MonitorStream, error)
+}
+
+var _ ClientInterface = (*Client)(nil)
+
+type MonitorWriter struct {
+	call *govarlink.ServerCall
+}
+
+func (w *MonitorWriter) Reply(out *MonitorOut) error {
+	return w.call.Reply(out)
+}
+
+type Backend interface {
+	// Generated with a regular wrapper.
+	Get(*GetIn) (*GetOut, error)
+	// Generated with streaming client and server wrappers.
+	Monitor(*MonitorIn, *MonitorWriter) (*MonitorOut, error)
+}
+
+type UnimplementedBackend struct{}
+
+var _ Backend = UnimplementedBackend{}
+
+func (UnimplementedBackend) Get(*GetIn) (*GetOut, error) {
+	return nil, &govarlink.ServerError{
+		Name:       "org.varlink.service.MethodNotImplemented",
+		Parameters: map[string]string{"method": "org.example.stream.Get"},
+	}
+}
+func (UnimplementedBackend) Monitor(*MonitorIn, *MonitorWriter) (*MonitorOut, error) {
+	return nil, &govarlink.ServerError{
+		Name:       "org.varlink.service.MethodNotImplemented",
+		Parameters: map[string]string{"method": "org.example.stream.Monitor"},
+	}
+}
+
+// Interface exercising methods returning multiple replies.
+type Handler struct {
+	Backend Backend
+}
+
+func marshalError(err error) error {
+	var name string
+	switch err.(type) {
+	case *InterruptedError:
+		name = "org.example.stream.Interrupted"
+	default:
+		return err
+	}
+	return &govarlink.ServerError{
+		Name:       name,
+		Parameters: err,
+	}
+}
+func (h Handler) HandleVarlink(call *govarlink.ServerCall, req *govarlink.ServerRequest) error {
+	var (
+		out interface{}
+		err error
+	)
+	switch req.Method {
+	case "org.example.stream.Get":
+		in := new(GetIn)
+		if err := json.Unmarshal(req.Parameters, in); err != nil {
+			return err
+		}
+		out, err = h.Backend.Get(in)
+	case "org.example.stream.Monitor":
+		in := new(MonitorIn)
+		if err := json.Unmarshal(req.Parameters, in); err != nil {
+			return err
+		}
+		if !req.More {
+			err = &govarlink.ServerError{Name: "org.varlink.service.ExpectedMore"}
+		} else {
+			out, err = h.Backend.Monitor(in, &MonitorWriter{call})
+		}
+	default:
+		ifaceName := req.Method
+		if i := strings.LastIndexByte(ifaceName, '.'); i >= 0 {
+			ifaceName = ifaceName[:i]
+		}
+		if ifaceName == "org.example.stream" {
+			err = &govarlink.ServerError{
+				Name:       "org.varlink.service.MethodNotFound",
+				Parameters: map[string]string{"method": req.Method},
+			}
+		} else {
+			err = &govarlink.ServerError{
+				Name:       "org.varlink.service.InterfaceNotFound",
+				Parameters: map[string]string{"interface": ifaceName},
+			}
+		}
+	}
+	if err != nil {
+		return marshalError(err)
+	}
+	return call.CloseWithReply(out)
+}
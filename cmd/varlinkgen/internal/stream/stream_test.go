@@ -0,0 +1,126 @@
+package stream
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/emersion/go-varlink"
+	"github.com/emersion/go-varlink/varlinktest"
+)
+
+type backend struct{}
+
+func (backend) Get(in *GetIn) (*GetOut, error) {
+	return &GetOut{}, nil
+}
+
+// Monitor replies count times, then fails with InterruptedError if count is
+// negative.
+func (backend) Monitor(in *MonitorIn, w *MonitorWriter) (*MonitorOut, error) {
+	n := in.Count
+	if n < 0 {
+		n = -n
+	}
+	for i := 0; i < n-1; i++ {
+		if err := w.Reply(&MonitorOut{Index: i}); err != nil {
+			return nil, err
+		}
+	}
+	if in.Count < 0 {
+		if err := w.Reply(&MonitorOut{Index: n - 1}); err != nil {
+			return nil, err
+		}
+		return nil, NewInterruptedError(n)
+	}
+	return &MonitorOut{Index: n - 1}, nil
+}
+
+func TestClient_Monitor(t *testing.T) {
+	c := Client{varlinktest.NewClient(t, Handler{Backend: backend{}})}
+
+	stream, err := c.Monitor(&MonitorIn{Count: 3})
+	if err != nil {
+		t.Fatalf("Monitor() = %v", err)
+	}
+	var got []int
+	for {
+		out, err := stream.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Next() = %v", err)
+		}
+		got = append(got, out.Index)
+	}
+	if len(got) != 3 || got[0] != 0 || got[1] != 1 || got[2] != 2 {
+		t.Errorf("Next() returned %v, want [0 1 2]", got)
+	}
+}
+
+func TestClient_Monitor_error(t *testing.T) {
+	c := Client{varlinktest.NewClient(t, Handler{Backend: backend{}})}
+
+	stream, err := c.Monitor(&MonitorIn{Count: -2})
+	if err != nil {
+		t.Fatalf("Monitor() = %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := stream.Next(); err != nil {
+			t.Fatalf("Next() = %v", err)
+		}
+	}
+	_, err = stream.Next()
+	var interrupted *InterruptedError
+	if !errors.As(err, &interrupted) {
+		t.Fatalf("Next() = %v, want an InterruptedError", err)
+	} else if interrupted.At != 2 {
+		t.Errorf("InterruptedError.At = %v, want 2", interrupted.At)
+	}
+}
+
+func TestHandler_Monitor_expectedMore(t *testing.T) {
+	c := Client{varlinktest.NewClient(t, Handler{Backend: backend{}})}
+
+	var out MonitorOut
+	err := c.Client.Do("org.example.stream.Monitor", &MonitorIn{Count: 1}, &out)
+	var clientErr *varlink.ClientError
+	if !errors.As(err, &clientErr) || clientErr.Name != "org.varlink.service.ExpectedMore" {
+		t.Errorf("Do() = %v, want org.varlink.service.ExpectedMore", err)
+	}
+}
+
+type partialBackend struct {
+	UnimplementedBackend
+}
+
+func (partialBackend) Get(in *GetIn) (*GetOut, error) {
+	return &GetOut{Index: 42}, nil
+}
+
+func TestUnimplementedBackend(t *testing.T) {
+	c := Client{varlinktest.NewClient(t, Handler{Backend: partialBackend{}})}
+
+	if out, err := c.Get(nil); err != nil {
+		t.Fatalf("Get() = %v", err)
+	} else if out.Index != 42 {
+		t.Errorf("Get() = %v, want 42", out.Index)
+	}
+
+	stream, err := c.Monitor(nil)
+	if err != nil {
+		t.Fatalf("Monitor() = %v", err)
+	}
+	_, err = stream.Next()
+	var clientErr *varlink.ClientError
+	if !errors.As(err, &clientErr) || clientErr.Name != "org.varlink.service.MethodNotImplemented" {
+		t.Fatalf("Next() = %v, want org.varlink.service.MethodNotImplemented", err)
+	}
+	var params struct{ Method string }
+	if err := json.Unmarshal(clientErr.Parameters, &params); err != nil {
+		t.Fatalf("json.Unmarshal() = %v", err)
+	} else if params.Method != "org.example.stream.Monitor" {
+		t.Errorf("MethodNotImplemented method = %q, want %q", params.Method, "org.example.stream.Monitor")
+	}
+}
@@ -0,0 +1,60 @@
+package timeout
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-varlink/varlinktest"
+)
+
+type backend struct {
+	release chan struct{}
+}
+
+func (be *backend) Wait(in *WaitIn) (*WaitOut, error) {
+	<-be.release
+	return &WaitOut{}, nil
+}
+
+func newTestClient(t *testing.T) (Client, chan struct{}) {
+	be := &backend{release: make(chan struct{})}
+	t.Cleanup(func() { close(be.release) })
+	return Client{Client: varlinktest.NewClient(t, Handler{Backend: be})}, be.release
+}
+
+func TestClient_defaultTimeout(t *testing.T) {
+	c, _ := newTestClient(t)
+
+	start := time.Now()
+	_, err := c.Wait(nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Wait() = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if d := time.Since(start); d < DefaultTimeout {
+		t.Errorf("Wait() returned after %v, want at least %v", d, DefaultTimeout)
+	}
+}
+
+func TestClient_Timeout(t *testing.T) {
+	c, release := newTestClient(t)
+	c.Timeout = -1
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.Wait(nil)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatalf("Wait() = %v before the service replied", err)
+	case <-time.After(2 * DefaultTimeout):
+	}
+
+	release <- struct{}{}
+	if err := <-done; err != nil {
+		t.Errorf("Wait() = %v", err)
+	}
+}
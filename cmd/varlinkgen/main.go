@@ -2,24 +2,80 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"go/token"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/dave/jennifer/jen"
 
+	"github.com/emersion/go-varlink/internal/naming"
 	"github.com/emersion/go-varlink/varlinkdef"
 )
 
+// tagFlag is a flag.Value holding extra struct tag templates.
+type tagFlag map[string]string
+
+func (tf tagFlag) String() string {
+	var l []string
+	for k, v := range tf {
+		l = append(l, k+"="+v)
+	}
+	sort.Strings(l)
+	return strings.Join(l, ",")
+}
+
+func (tf tagFlag) Set(s string) error {
+	k, v, ok := strings.Cut(s, "=")
+	if !ok || k == "" {
+		return fmt.Errorf("expected KEY=TEMPLATE, got %q", s)
+	} else if k == "json" {
+		return fmt.Errorf("json tags are generated from the Varlink field name and cannot be overridden")
+	}
+	tf[k] = v
+	return nil
+}
+
+// methodSetFlag is a flag.Value holding a set of method names.
+type methodSetFlag map[string]bool
+
+func (mf methodSetFlag) String() string {
+	var l []string
+	for k := range mf {
+		l = append(l, k)
+	}
+	sort.Strings(l)
+	return strings.Join(l, ",")
+}
+
+func (mf methodSetFlag) Set(s string) error {
+	for _, name := range strings.Split(s, ",") {
+		if name == "" {
+			return fmt.Errorf("empty method name in %q", s)
+		}
+		mf[name] = true
+	}
+	return nil
+}
+
 func main() {
 	var inFilename, outFilename, pkgName string
-	var genError bool
+	gen := generator{extraTags: make(tagFlag), oneway: make(methodSetFlag), more: make(methodSetFlag)}
 	flag.StringVar(&inFilename, "i", "", "input filename")
-	flag.StringVar(&outFilename, "o", "", "output filename")
-	flag.StringVar(&pkgName, "n", "", "package name")
-	flag.BoolVar(&genError, "gen-error-impl", true, "generate error.Error() default implementations")
+	flag.StringVar(&outFilename, "o", "", "output filename (\"-\" for stdout)")
+	flag.StringVar(&pkgName, "package", "", "package name (defaults to the output directory name)")
+	flag.StringVar(&pkgName, "n", "", "shorthand for -package")
+	flag.BoolVar(&gen.genError, "gen-error-impl", true, "generate error.Error() default implementations")
+	flag.Var(gen.extraTags, "tag", "extra struct tag for generated fields, as KEY=TEMPLATE (\"{name}\" in TEMPLATE is replaced with the Varlink field name)")
+	flag.Var(gen.oneway, "oneway", "comma-separated list of methods to generate oneway client wrappers for")
+	flag.Var(gen.more, "more", "comma-separated list of methods returning multiple replies")
+	flag.DurationVar(&gen.timeout, "timeout", 0, "default timeout for client calls (0 means no timeout)")
+	flag.BoolVar(&gen.context, "context", false, "pass the call context to Backend methods")
 	flag.Parse()
 
 	if inFilename == "" {
@@ -31,22 +87,78 @@ func main() {
 	}
 
 	if pkgName == "" {
-		abs, err := filepath.Abs(outFilename)
+		pkgFilename := outFilename
+		if pkgFilename == "-" {
+			pkgFilename = inFilename
+		}
+		abs, err := filepath.Abs(pkgFilename)
 		if err != nil {
 			log.Fatalf("failed to get absolute output filename: %v", err)
 		}
 		pkgName = filepath.Base(filepath.Dir(abs))
 	}
+	if err := checkPackageName(pkgName); err != nil {
+		log.Fatal(err)
+	}
 
 	iface, err := loadInterface(inFilename)
 	if err != nil {
 		log.Fatalf("failed to load Varlink interface definition: %v", err)
 	}
 
+	for flagName, methods := range map[string]methodSetFlag{"oneway": gen.oneway, "more": gen.more} {
+		for name := range methods {
+			if _, ok := iface.Methods[name]; !ok {
+				log.Fatalf("-%v: method %q not found in interface", flagName, name)
+			}
+		}
+	}
+
+	f := gen.generate(iface, pkgName)
+	if err := writeOutput(f, outFilename, os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// checkPackageName checks that name can be used in a package clause.
+func checkPackageName(name string) error {
+	if !token.IsIdentifier(name) || name == "_" {
+		return fmt.Errorf("invalid package name %q: not a Go identifier", name)
+	}
+	return nil
+}
+
+// writeOutput saves the generated file to filename, or writes it to stdout if
+// filename is "-".
+func writeOutput(f *jen.File, filename string, stdout io.Writer) error {
+	if filename == "-" {
+		return f.Render(stdout)
+	}
+	return f.Save(filename)
+}
+
+type generator struct {
+	genError  bool
+	extraTags tagFlag
+	oneway    methodSetFlag
+	more      methodSetFlag
+	timeout   time.Duration
+	context   bool
+}
+
+func (gen *generator) generate(iface *varlinkdef.Interface, pkgName string) *jen.File {
 	f := jen.NewFile(pkgName)
 
 	f.HeaderComment("// Code generated by go-varlink/varlinkgen. DO NOT EDIT.")
 
+	f.PackageComment(fmt.Sprintf("Package %v implements the %v Varlink interface.", pkgName, iface.Name))
+	if iface.Doc != "" {
+		f.PackageComment("")
+		for _, line := range strings.Split(iface.Doc, "\n") {
+			f.PackageComment(line)
+		}
+	}
+
 	var typeNames []string
 	for name := range iface.Types {
 		typeNames = append(typeNames, name)
@@ -55,17 +167,15 @@ func main() {
 
 	for _, name := range typeNames {
 		typ := iface.Types[name]
+		doc := genDoc(iface.MemberDocs[name])
 		switch typ.Kind {
 		case varlinkdef.KindStruct:
-			f.Type().Id(name).Add(genType(&typ))
+			f.Add(doc).Type().Id(name).Add(gen.genType(name, &typ))
+			gen.genValidate(f, iface, name, typ.Struct)
+			gen.genInlineEnums(f, name, &typ)
 		case varlinkdef.KindEnum:
-			var defs []jen.Code
-			for _, k := range typ.Enum {
-				defs = append(defs, jen.Id(name+goName(k)).Id(name).Op("=").Lit(k))
-			}
-
-			f.Type().Id(name).String()
-			f.Const().Defs(defs...)
+			f.Add(doc)
+			gen.genEnum(f, name, typ.Enum)
 		default:
 			panic("unreachable")
 		}
@@ -81,8 +191,8 @@ func main() {
 
 	for _, name := range errorNames {
 		err := iface.Errors[name]
-		f.Type().Id(name + "Error").Add(genStruct(err))
-		if genError {
+		f.Add(genDoc(iface.MemberDocs[name])).Type().Id(name + "Error").Add(gen.genStruct(name+"Error", err))
+		if gen.genError {
 			f.Func().Params(
 				jen.Id("err").Op("*").Id(name + "Error"),
 			).Id("Error").Params().String().Block(
@@ -91,6 +201,8 @@ func main() {
 		} else {
 			f.Var().Id("_").Id("error").Op("=").Parens(jen.Op("*").Id(name + "Error")).Parens(jen.Nil())
 		}
+		gen.genErrorConstructor(f, name, err)
+		gen.genInlineEnums(f, name+"Error", &varlinkdef.Type{Kind: varlinkdef.KindStruct, Struct: err})
 	}
 
 	f.Line()
@@ -104,14 +216,22 @@ func main() {
 	for _, name := range methodNames {
 		method := iface.Methods[name]
 
-		f.Type().Id(name + "In").Add(genStruct(method.In))
-		f.Type().Id(name + "Out").Add(genStruct(method.Out))
+		f.Add(genDoc(iface.MemberDocs[name])).Type().Id(name + "In").Add(gen.genStruct(name+"In", method.In))
+		gen.genValidate(f, iface, name+"In", method.In)
+		gen.genInlineEnums(f, name+"In", &varlinkdef.Type{Kind: varlinkdef.KindStruct, Struct: method.In})
+		f.Add(genDoc(iface.MemberDocs[name])).Type().Id(name + "Out").Add(gen.genStruct(name+"Out", method.Out))
+		gen.genValidate(f, iface, name+"Out", method.Out)
+		gen.genInlineEnums(f, name+"Out", &varlinkdef.Type{Kind: varlinkdef.KindStruct, Struct: method.Out})
 		f.Line()
 	}
 
-	f.Type().Id("Client").Struct(
-		jen.Op("*").Qual("github.com/emersion/go-varlink", "Client"),
-	)
+	if gen.timeout > 0 {
+		gen.genClientTimeout(f)
+	} else {
+		f.Type().Id("Client").Struct(
+			jen.Op("*").Qual("github.com/emersion/go-varlink", "Client"),
+		)
+	}
 
 	f.Line()
 
@@ -131,18 +251,20 @@ func main() {
 	// coming from foreign interfaces can be used
 	// TODO: consider wrapping the original varlink.Error inside the
 	// unmarshalled one
-	f.Func().Id("unmarshalError").Params(
-		jen.Id("err").Id("error"),
-	).Id("error").Block(
+	unmarshalErrorBody := []jen.Code{
 		jen.List(jen.Id("verr"), jen.Id("ok")).Op(":=").Id("err").Assert(jen.Op("*").Qual("github.com/emersion/go-varlink", "ClientError")),
 		jen.If(jen.Op("!").Id("ok")).Block(
 			jen.Return().Id("err"),
 		),
 		jen.Var().Id("v").Id("error"),
 		jen.Switch(jen.Id("verr").Dot("Name")).Block(errCases...),
+		jen.Id("params").Op(":=").Id("verr").Dot("Parameters"),
+		jen.If(jen.Len(jen.Id("params")).Op("==").Lit(0)).Block(
+			jen.Id("params").Op("=").Qual("encoding/json", "RawMessage").Call(jen.Lit("{}")),
+		),
 		jen.If(
 			jen.Id("err").Op(":=").Qual("encoding/json", "Unmarshal").Call(
-				jen.Id("verr").Dot("Parameters"),
+				jen.Id("params"),
 				jen.Id("v"),
 			),
 			jen.Id("err").Op("!=").Nil(),
@@ -150,39 +272,72 @@ func main() {
 			jen.Return().Id("err"),
 		),
 		jen.Return().Id("v"),
-	)
+	}
+	if len(errorNames) == 0 {
+		// No errors are defined, avoid generating unreachable code
+		unmarshalErrorBody = []jen.Code{jen.Return().Id("err")}
+	}
+	f.Func().Id("unmarshalError").Params(
+		jen.Id("err").Id("error"),
+	).Id("error").Block(unmarshalErrorBody...)
 
 	for _, name := range methodNames {
-		f.Func().Params(
-			jen.Id("c").Id("Client"),
-		).Id(name).Params(
-			jen.Id("in").Op("*").Id(name+"In"),
-		).Params(
-			jen.Op("*").Id(name+"Out"),
-			jen.Id("error"),
-		).Block(
-			jen.If(jen.Id("in").Op("==").Nil()).Block(
-				jen.Id("in").Op("=").New(jen.Id(name+"In")),
-			),
-			jen.Id("out").Op(":=").New(jen.Id(name+"Out")),
-			jen.Id("err").Op(":=").Id("c").Dot("Client").Dot("Do").Call(
-				jen.Lit(iface.Name+"."+name),
-				jen.Id("in"),
-				jen.Id("out"),
-			),
-			jen.Return().List(
-				jen.Id("out"),
-				jen.Id("unmarshalError").Call(jen.Id("err")),
-			),
-		)
+		if gen.more[name] {
+			gen.genClientStream(f, iface, name)
+		} else {
+			f.Add(genDoc(iface.MemberDocs[name])).Func().Params(
+				jen.Id("c").Id("Client"),
+			).Id(name).Params(
+				jen.Id("in").Op("*").Id(name+"In"),
+			).Params(
+				jen.Op("*").Id(name+"Out"),
+				jen.Id("error"),
+			).Block(
+				jen.If(jen.Id("in").Op("==").Nil()).Block(
+					jen.Id("in").Op("=").New(jen.Id(name+"In")),
+				),
+				jen.Id("out").Op(":=").New(jen.Id(name+"Out")),
+				gen.genClientCall(iface.Name+"."+name),
+				jen.Return().List(
+					jen.Id("out"),
+					jen.Id("unmarshalError").Call(jen.Id("err")),
+				),
+			)
+		}
+
+		if gen.oneway[name] {
+			f.Func().Params(
+				jen.Id("c").Id("Client"),
+			).Id(name+"Oneway").Params(
+				jen.Id("in").Op("*").Id(name+"In"),
+			).Error().Block(
+				jen.If(jen.Id("in").Op("==").Nil()).Block(
+					jen.Id("in").Op("=").New(jen.Id(name+"In")),
+				),
+				jen.Return().Id("c").Dot("Client").Dot("DoOneway").Call(
+					jen.Lit(iface.Name+"."+name),
+					jen.Id("in"),
+				),
+			)
+		}
 	}
 
 	f.Line()
 
+	gen.genClientInterface(f, iface, methodNames)
+
+	f.Line()
+
+	for _, name := range methodNames {
+		if gen.more[name] {
+			gen.genServerStream(f, name)
+		}
+	}
+
 	var backendMethods []jen.Code
 	for _, name := range methodNames {
-		backendMethods = append(backendMethods, jen.Id(name).Params(
-			jen.Op("*").Id(name+"In"),
+		backendMethods = append(backendMethods, genDoc(iface.MemberDocs[name]).Id(name).Params(
+			gen.backendParams(name)...,
 		).Params(
 			jen.Op("*").Id(name+"Out"),
 			jen.Id("error"),
@@ -193,7 +348,11 @@ func main() {
 
 	f.Line()
 
-	f.Type().Id("Handler").Struct(
+	gen.genUnimplementedBackend(f, iface, methodNames)
+
+	f.Line()
+
+	f.Add(genDoc(iface.Doc)).Type().Id("Handler").Struct(
 		jen.Id("Backend").Id("Backend"),
 	)
 
@@ -207,19 +366,36 @@ func main() {
 		jen.Return().Id("err"),
 	))
 
-	f.Func().Id("marshalError").Params(
-		jen.Id("err").Id("error"),
-	).Id("error").Block(
+	marshalErrorBody := []jen.Code{
 		jen.Var().Id("name").String(),
 		jen.Switch(jen.Id("err").Assert(jen.Type())).Block(errCases...),
 		jen.Return().Op("&").Qual("github.com/emersion/go-varlink", "ServerError").Values(jen.Dict{
 			jen.Id("Name"):       jen.Id("name"),
 			jen.Id("Parameters"): jen.Id("err"),
 		}),
-	)
+	}
+	if len(errorNames) == 0 {
+		marshalErrorBody = []jen.Code{jen.Return().Id("err")}
+	}
+	f.Func().Id("marshalError").Params(
+		jen.Id("err").Id("error"),
+	).Id("error").Block(marshalErrorBody...)
 
 	var methodCases []jen.Code
 	for _, name := range methodNames {
+		var call jen.Code = jen.List(jen.Id("out"), jen.Id("err")).Op("=").Id("h").Dot("Backend").Dot(name).Call(gen.backendArgs(jen.Id("in"))...)
+		if gen.more[name] {
+			call = jen.If(jen.Op("!").Id("req").Dot("More")).Block(
+				jen.Id("err").Op("=").Op("&").Qual("github.com/emersion/go-varlink", "ServerError").Values(jen.Dict{
+					jen.Id("Name"): jen.Lit("org.varlink.service.ExpectedMore"),
+				}),
+			).Else().Block(
+				jen.List(jen.Id("out"), jen.Id("err")).Op("=").Id("h").Dot("Backend").Dot(name).Call(gen.backendArgs(
+					jen.Id("in"),
+					jen.Op("&").Id(name+"Writer").Values(jen.Id("call")),
+				)...),
+			)
+		}
 		methodCases = append(methodCases, jen.Case(jen.Lit(iface.Name+"."+name)).Block(
 			jen.Id("in").Op(":=").New(jen.Id(name+"In")),
 			jen.If(
@@ -231,17 +407,33 @@ func main() {
 			).Block(
 				jen.Return().Id("err"),
 			),
-			jen.List(jen.Id("out"), jen.Id("err")).Op("=").Id("h").Dot("Backend").Dot(name).Call(jen.Id("in")),
+			call,
 		))
 	}
 	methodCases = append(methodCases, jen.Default().Block(
+		jen.Id("ifaceName").Op(":=").Id("req").Dot("Method"),
+		jen.If(
+			jen.Id("i").Op(":=").Qual("strings", "LastIndexByte").Call(jen.Id("ifaceName"), jen.LitRune('.')),
+			jen.Id("i").Op(">=").Lit(0),
+		).Block(
+			jen.Id("ifaceName").Op("=").Id("ifaceName").Index(jen.Empty(), jen.Id("i")),
+		),
 		// TODO: consider using a generated error struct
-		jen.Id("err").Op("=").Op("&").Qual("github.com/emersion/go-varlink", "ServerError").Values(jen.Dict{
-			jen.Id("Name"): jen.Lit("org.varlink.service.MethodNotFound"),
-			jen.Id("Parameters"): jen.Map(jen.String()).String().Values(jen.Dict{
-				jen.Lit("method"): jen.Id("req").Dot("Method"),
+		jen.If(jen.Id("ifaceName").Op("==").Lit(iface.Name)).Block(
+			jen.Id("err").Op("=").Op("&").Qual("github.com/emersion/go-varlink", "ServerError").Values(jen.Dict{
+				jen.Id("Name"): jen.Lit("org.varlink.service.MethodNotFound"),
+				jen.Id("Parameters"): jen.Map(jen.String()).String().Values(jen.Dict{
+					jen.Lit("method"): jen.Id("req").Dot("Method"),
+				}),
 			}),
-		}),
+		).Else().Block(
+			jen.Id("err").Op("=").Op("&").Qual("github.com/emersion/go-varlink", "ServerError").Values(jen.Dict{
+				jen.Id("Name"): jen.Lit("org.varlink.service.InterfaceNotFound"),
+				jen.Id("Parameters"): jen.Map(jen.String()).String().Values(jen.Dict{
+					jen.Lit("interface"): jen.Id("ifaceName"),
+				}),
+			}),
+		),
 	))
 
 	f.Func().Params(
@@ -261,9 +453,226 @@ func main() {
 		jen.Return().Id("call").Dot("CloseWithReply").Call(jen.Id("out")),
 	)
 
-	if err := f.Save(outFilename); err != nil {
-		log.Fatal(err)
+	return f
+}
+
+// genClientTimeout generates the Client type along with the DefaultTimeout
+// constant, for generators with a default timeout.
+func (gen *generator) genClientTimeout(f *jen.File) {
+	f.Comment("DefaultTimeout is the default timeout of client calls.")
+	f.Const().Id("DefaultTimeout").Op("=").Add(genDuration(gen.timeout))
+
+	f.Line()
+
+	f.Type().Id("Client").Struct(
+		jen.Op("*").Qual("github.com/emersion/go-varlink", "Client"),
+		jen.Line(),
+		jen.Comment("Timeout overrides DefaultTimeout for calls with a single reply. A"),
+		jen.Comment("negative value disables the timeout."),
+		jen.Id("Timeout").Qual("time", "Duration"),
+	)
+
+	f.Line()
+
+	f.Func().Params(
+		jen.Id("c").Id("Client"),
+	).Id("timeout").Params().Qual("time", "Duration").Block(
+		jen.If(jen.Id("c").Dot("Timeout").Op("!=").Lit(0)).Block(
+			jen.Return().Id("c").Dot("Timeout"),
+		),
+		jen.Return().Id("DefaultTimeout"),
+	)
+}
+
+// genClientCall generates a call to method with the in and out variables,
+// applying the client timeout if any.
+func (gen *generator) genClientCall(method string) jen.Code {
+	if gen.timeout <= 0 {
+		return jen.Id("err").Op(":=").Id("c").Dot("Client").Dot("Do").Call(
+			jen.Lit(method),
+			jen.Id("in"),
+			jen.Id("out"),
+		)
+	}
+	return jen.Id("err").Op(":=").Id("c").Dot("Client").Dot("Call").Call(
+		jen.Lit(method),
+		jen.Id("in"),
+		jen.Id("out"),
+		jen.Qual("github.com/emersion/go-varlink", "WithTimeout").Call(jen.Id("c").Dot("timeout").Call()),
+	)
+}
+
+// genDuration generates a time.Duration expression for d, using the largest
+// unit which divides it, e.g. "5 * time.Second".
+func genDuration(d time.Duration) *jen.Statement {
+	units := []struct {
+		name string
+		d    time.Duration
+	}{
+		{"Hour", time.Hour},
+		{"Minute", time.Minute},
+		{"Second", time.Second},
+		{"Millisecond", time.Millisecond},
+		{"Microsecond", time.Microsecond},
+	}
+	for _, unit := range units {
+		if d%unit.d == 0 {
+			return jen.Lit(int(d/unit.d)).Op("*").Qual("time", unit.name)
+		}
+	}
+	return jen.Lit(int(d)).Op("*").Qual("time", "Nanosecond")
+}
+
+// genClientStream generates a client wrapper for a method returning multiple
+// replies, along with a FooStream type to read them.
+func (gen *generator) genClientStream(f *jen.File, iface *varlinkdef.Interface, name string) {
+	streamName := name + "Stream"
+
+	f.Type().Id(streamName).Struct(
+		jen.Id("call").Op("*").Qual("github.com/emersion/go-varlink", "ClientCall"),
+	)
+
+	f.Func().Params(
+		jen.Id("s").Op("*").Id(streamName),
+	).Id("Next").Params().Params(
+		jen.Op("*").Id(name+"Out"),
+		jen.Id("error"),
+	).Block(
+		jen.Id("out").Op(":=").New(jen.Id(name+"Out")),
+		jen.If(
+			jen.Err().Op(":=").Id("s").Dot("call").Dot("Next").Call(jen.Id("out")),
+			jen.Err().Op("!=").Nil(),
+		).Block(
+			jen.Return().List(jen.Nil(), jen.Id("unmarshalError").Call(jen.Err())),
+		),
+		jen.Return().List(jen.Id("out"), jen.Nil()),
+	)
+
+	f.Add(genDoc(iface.MemberDocs[name])).Func().Params(
+		jen.Id("c").Id("Client"),
+	).Id(name).Params(
+		jen.Id("in").Op("*").Id(name+"In"),
+	).Params(
+		jen.Op("*").Id(streamName),
+		jen.Id("error"),
+	).Block(
+		jen.If(jen.Id("in").Op("==").Nil()).Block(
+			jen.Id("in").Op("=").New(jen.Id(name+"In")),
+		),
+		jen.List(jen.Id("call"), jen.Err()).Op(":=").Id("c").Dot("Client").Dot("DoMore").Call(
+			jen.Lit(iface.Name+"."+name),
+			jen.Id("in"),
+		),
+		jen.If(jen.Err().Op("!=").Nil()).Block(
+			jen.Return().List(jen.Nil(), jen.Err()),
+		),
+		jen.Return().List(jen.Op("&").Id(streamName).Values(jen.Id("call")), jen.Nil()),
+	)
+}
+
+// genClientInterface generates a ClientInterface type with the methods of
+// Client, so that callers can substitute a mock implementation.
+func (gen *generator) genClientInterface(f *jen.File, iface *varlinkdef.Interface, methodNames []string) {
+	var methods []jen.Code
+	for _, name := range methodNames {
+		result := name + "Out"
+		if gen.more[name] {
+			result = name + "Stream"
+		}
+		methods = append(methods, genDoc(iface.MemberDocs[name]).Id(name).Params(
+			jen.Op("*").Id(name+"In"),
+		).Params(
+			jen.Op("*").Id(result),
+			jen.Id("error"),
+		))
+		if gen.oneway[name] {
+			methods = append(methods, jen.Id(name+"Oneway").Params(
+				jen.Op("*").Id(name+"In"),
+			).Error())
+		}
 	}
+
+	f.Type().Id("ClientInterface").Interface(methods...)
+
+	f.Var().Id("_").Id("ClientInterface").Op("=").Parens(jen.Op("*").Id("Client")).Parens(jen.Nil())
+}
+
+// backendParams returns the parameters of the Backend method for name.
+func (gen *generator) backendParams(name string) []jen.Code {
+	var params []jen.Code
+	if gen.context {
+		params = append(params, jen.Qual("context", "Context"))
+	}
+	params = append(params, jen.Op("*").Id(name+"In"))
+	if gen.more[name] {
+		params = append(params, jen.Op("*").Id(name+"Writer"))
+	}
+	return params
+}
+
+// backendArgs returns the arguments passed to a Backend method by the
+// Handler, prepending the call context if enabled.
+func (gen *generator) backendArgs(args ...jen.Code) []jen.Code {
+	if gen.context {
+		args = append([]jen.Code{jen.Id("call").Dot("Context").Call()}, args...)
+	}
+	return args
+}
+
+// genUnimplementedBackend generates an UnimplementedBackend type whose
+// methods all fail with org.varlink.service.MethodNotImplemented. It can be
+// embedded in a partial Backend implementation.
+func (gen *generator) genUnimplementedBackend(f *jen.File, iface *varlinkdef.Interface, methodNames []string) {
+	f.Type().Id("UnimplementedBackend").Struct()
+
+	f.Var().Id("_").Id("Backend").Op("=").Id("UnimplementedBackend").Values()
+
+	for _, name := range methodNames {
+		f.Func().Params(
+			jen.Id("UnimplementedBackend"),
+		).Id(name).Params(gen.backendParams(name)...).Params(
+			jen.Op("*").Id(name+"Out"),
+			jen.Id("error"),
+		).Block(
+			jen.Return().List(jen.Nil(), jen.Op("&").Qual("github.com/emersion/go-varlink", "ServerError").Values(jen.Dict{
+				jen.Id("Name"): jen.Lit("org.varlink.service.MethodNotImplemented"),
+				jen.Id("Parameters"): jen.Map(jen.String()).String().Values(jen.Dict{
+					jen.Lit("method"): jen.Lit(iface.Name + "." + name),
+				}),
+			})),
+		)
+	}
+}
+
+// genServerStream generates a FooWriter type, passed to the backend of a
+// method returning multiple replies to send all but the last one.
+func (gen *generator) genServerStream(f *jen.File, name string) {
+	writerName := name + "Writer"
+
+	f.Type().Id(writerName).Struct(
+		jen.Id("call").Op("*").Qual("github.com/emersion/go-varlink", "ServerCall"),
+	)
+
+	f.Func().Params(
+		jen.Id("w").Op("*").Id(writerName),
+	).Id("Reply").Params(
+		jen.Id("out").Op("*").Id(name + "Out"),
+	).Error().Block(
+		jen.Return().Id("w").Dot("call").Dot("Reply").Call(jen.Id("out")),
+	)
+}
+
+// genDoc generates a comment block from a Varlink documentation comment. An
+// empty statement is returned if doc is empty.
+func genDoc(doc string) *jen.Statement {
+	s := jen.Null()
+	if doc == "" {
+		return s
+	}
+	for _, line := range strings.Split(doc, "\n") {
+		s.Comment(line).Line()
+	}
+	return s
 }
 
 func loadInterface(filename string) (*varlinkdef.Interface, error) {
@@ -276,20 +685,22 @@ func loadInterface(filename string) (*varlinkdef.Interface, error) {
 	return varlinkdef.Read(f)
 }
 
-func genType(typ *varlinkdef.Type) jen.Code {
+// genType generates a Go type for a Varlink type. name is the Go type name
+// used for inline enums, see genInlineEnums.
+func (gen *generator) genType(name string, typ *varlinkdef.Type) jen.Code {
 	if typ.Nullable {
 		t := *typ
 		t.Nullable = false
-		return jen.Op("*").Add(genType(&t))
+		return jen.Op("*").Add(gen.genType(name, &t))
 	}
 
 	switch typ.Kind {
 	case varlinkdef.KindStruct:
-		return genStruct(typ.Struct)
+		return gen.genStruct(name, typ.Struct)
 	case varlinkdef.KindEnum:
-		return jen.String() // TODO
+		return jen.Id(name)
 	case varlinkdef.KindName:
-		return jen.Id(goName(typ.Name))
+		return jen.Id(naming.GoName(typ.Name))
 	case varlinkdef.KindBool:
 		return jen.Bool()
 	case varlinkdef.KindInt:
@@ -301,15 +712,15 @@ func genType(typ *varlinkdef.Type) jen.Code {
 	case varlinkdef.KindObject:
 		return jen.Qual("encoding/json", "RawMessage")
 	case varlinkdef.KindArray:
-		return jen.Index().Add(genType(typ.Inner))
+		return jen.Index().Add(gen.genType(name, typ.Inner))
 	case varlinkdef.KindMap:
-		return jen.Map(jen.String()).Add(genType(typ.Inner))
+		return jen.Map(jen.String()).Add(gen.genType(name, typ.Inner))
 	default:
 		panic("unreachable")
 	}
 }
 
-func genStruct(def varlinkdef.Struct) jen.Code {
+func (gen *generator) genStruct(name string, def varlinkdef.Struct) jen.Code {
 	var keys []string
 	for k := range def {
 		keys = append(keys, k)
@@ -324,16 +735,235 @@ func genStruct(def varlinkdef.Struct) jen.Code {
 		if t.Nullable {
 			tag["json"] += ",omitempty"
 		}
+		for tagKey, tmpl := range gen.extraTags {
+			tag[tagKey] = strings.ReplaceAll(tmpl, "{name}", k)
+		}
 
-		fields = append(fields, jen.Id(goName(k)).Add(genType(&t)).Tag(tag))
+		fields = append(fields, jen.Id(naming.GoName(k)).Add(gen.genType(name+naming.GoName(k), &t)).Tag(tag))
 	}
 
 	return jen.Struct(fields...)
 }
 
-func goName(name string) string {
-	name = strings.ReplaceAll(name, "_", " ")
-	name = strings.Title(name)
-	name = strings.ReplaceAll(name, " ", "")
+// genEnum generates a named string type for an enum, along with one constant
+// per value.
+func (gen *generator) genEnum(f *jen.File, name string, enum varlinkdef.Enum) {
+	var defs []jen.Code
+	for _, k := range enum {
+		defs = append(defs, jen.Id(name+naming.GoName(k)).Id(name).Op("=").Lit(k))
+	}
+
+	f.Type().Id(name).String()
+	f.Const().Defs(defs...)
+	gen.genEnumUnmarshal(f, name, enum)
+}
+
+// genInlineEnums generates named types for the enums defined inline in typ,
+// e.g. in struct fields. The type of an inline enum is named after the
+// enclosing type and the field, e.g. "DriveConditionState" for the "state"
+// field of "DriveCondition".
+func (gen *generator) genInlineEnums(f *jen.File, name string, typ *varlinkdef.Type) {
+	switch typ.Kind {
+	case varlinkdef.KindEnum:
+		gen.genEnum(f, name, typ.Enum)
+	case varlinkdef.KindStruct:
+		var keys []string
+		for k := range typ.Struct {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			t := typ.Struct[k]
+			gen.genInlineEnums(f, name+naming.GoName(k), &t)
+		}
+	case varlinkdef.KindArray, varlinkdef.KindMap:
+		gen.genInlineEnums(f, name, typ.Inner)
+	}
+}
+
+// genEnumUnmarshal generates an UnmarshalJSON method for an enum type, which
+// rejects values not defined in the enum.
+func (gen *generator) genEnumUnmarshal(f *jen.File, name string, enum varlinkdef.Enum) {
+	var values []jen.Code
+	for _, k := range enum {
+		values = append(values, jen.Id(name+naming.GoName(k)))
+	}
+
+	f.Func().Params(
+		jen.Id("v").Op("*").Id(name),
+	).Id("UnmarshalJSON").Params(jen.Id("b").Index().Byte()).Error().Block(
+		jen.Var().Id("s").String(),
+		jen.If(
+			jen.Err().Op(":=").Qual("encoding/json", "Unmarshal").Call(jen.Id("b"), jen.Op("&").Id("s")),
+			jen.Err().Op("!=").Nil(),
+		).Block(
+			jen.Return().Err(),
+		),
+		jen.Switch(jen.Id(name).Call(jen.Id("s"))).Block(
+			jen.Case(values...),
+			jen.Default().Block(
+				jen.Return().Qual("fmt", "Errorf").Call(jen.Lit("invalid value %q for enum %q"), jen.Id("s"), jen.Lit(name)),
+			),
+		),
+		jen.Op("*").Id("v").Op("=").Id(name).Call(jen.Id("s")),
+		jen.Return().Nil(),
+	)
+}
+
+// genErrorConstructor generates a NewFooError function taking the error
+// parameters as arguments, in the same order as the struct fields.
+func (gen *generator) genErrorConstructor(f *jen.File, name string, def varlinkdef.Struct) {
+	var keys []string
+	for k := range def {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var params []jen.Code
+	values := jen.Dict{}
+	for _, k := range keys {
+		t := def[k]
+		param := paramName(k)
+		params = append(params, jen.Id(param).Add(gen.genType(name+"Error"+naming.GoName(k), &t)))
+		values[jen.Id(naming.GoName(k))] = jen.Id(param)
+	}
+
+	f.Func().Id("New" + name + "Error").Params(params...).Op("*").Id(name + "Error").Block(
+		jen.Return().Op("&").Id(name + "Error").Values(values),
+	)
+}
+
+// genValidate generates a Validate method for a struct type. The method
+// checks that enum fields hold one of the allowed values and that
+// non-nullable arrays, maps and objects are present.
+func (gen *generator) genValidate(f *jen.File, iface *varlinkdef.Interface, name string, def varlinkdef.Struct) {
+	body := gen.genValidateStruct(iface, jen.Id("v"), "", def, 0)
+	body = append(body, jen.Return().Nil())
+	f.Func().Params(
+		jen.Id("v").Op("*").Id(name),
+	).Id("Validate").Params().Error().Block(body...)
+}
+
+func (gen *generator) genValidateStruct(iface *varlinkdef.Interface, v *jen.Statement, path string, def varlinkdef.Struct, depth int) []jen.Code {
+	var keys []string
+	for k := range def {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var stmts []jen.Code
+	for _, k := range keys {
+		t := def[k]
+		fieldPath := k
+		if path != "" {
+			fieldPath = path + "." + k
+		}
+		stmts = append(stmts, gen.genValidateType(iface, v.Clone().Dot(naming.GoName(k)), fieldPath, &t, depth)...)
+	}
+	return stmts
+}
+
+func (gen *generator) genValidateType(iface *varlinkdef.Interface, v *jen.Statement, path string, typ *varlinkdef.Type, depth int) []jen.Code {
+	if typ.Nullable {
+		t := *typ
+		t.Nullable = false
+		// Fields and methods are accessible through the pointer
+		elem := v
+		if !isStruct(iface, &t) {
+			elem = jen.Op("*").Add(v)
+		}
+		body := gen.genValidateValue(iface, elem, path, &t, depth)
+		if len(body) == 0 {
+			return nil
+		}
+		return []jen.Code{jen.If(v.Clone().Op("!=").Nil()).Block(body...)}
+	}
+
+	switch typ.Kind {
+	case varlinkdef.KindArray, varlinkdef.KindMap, varlinkdef.KindObject:
+		stmts := []jen.Code{jen.If(v.Clone().Op("==").Nil()).Block(
+			jen.Return().Qual("fmt", "Errorf").Call(jen.Lit("missing field %q"), jen.Lit(path)),
+		)}
+		return append(stmts, gen.genValidateValue(iface, v, path, typ, depth)...)
+	default:
+		return gen.genValidateValue(iface, v, path, typ, depth)
+	}
+}
+
+// genValidateValue is similar to genValidateType, but assumes the value is
+// present.
+func (gen *generator) genValidateValue(iface *varlinkdef.Interface, v *jen.Statement, path string, typ *varlinkdef.Type, depth int) []jen.Code {
+	switch typ.Kind {
+	case varlinkdef.KindStruct:
+		return gen.genValidateStruct(iface, v, path, typ.Struct, depth)
+	case varlinkdef.KindEnum:
+		return []jen.Code{genValidateEnum(v, path, typ.Enum)}
+	case varlinkdef.KindName:
+		named, ok := iface.Types[typ.Name]
+		if !ok {
+			return nil
+		}
+		switch named.Kind {
+		case varlinkdef.KindEnum:
+			return []jen.Code{genValidateEnum(v, path, named.Enum)}
+		case varlinkdef.KindStruct:
+			return []jen.Code{jen.If(
+				jen.Err().Op(":=").Add(v).Dot("Validate").Call(),
+				jen.Err().Op("!=").Nil(),
+			).Block(
+				jen.Return().Qual("fmt", "Errorf").Call(jen.Lit("invalid field %q: %v"), jen.Lit(path), jen.Err()),
+			)}
+		}
+		return nil
+	case varlinkdef.KindArray, varlinkdef.KindMap:
+		elem := jen.Id(fmt.Sprintf("v%v", depth+1))
+		body := gen.genValidateType(iface, elem, path+"[]", typ.Inner, depth+1)
+		if len(body) == 0 {
+			return nil
+		}
+		return []jen.Code{jen.For(
+			jen.List(jen.Id("_"), elem.Clone()).Op(":=").Range().Add(v),
+		).Block(body...)}
+	default:
+		return nil
+	}
+}
+
+func isStruct(iface *varlinkdef.Interface, typ *varlinkdef.Type) bool {
+	if typ.Kind == varlinkdef.KindName {
+		named, ok := iface.Types[typ.Name]
+		return ok && named.Kind == varlinkdef.KindStruct
+	}
+	return typ.Kind == varlinkdef.KindStruct
+}
+
+func genValidateEnum(v *jen.Statement, path string, values varlinkdef.Enum) jen.Code {
+	var lits []jen.Code
+	for _, value := range values {
+		lits = append(lits, jen.Lit(value))
+	}
+	return jen.Switch(v).Block(
+		jen.Case(lits...),
+		jen.Default().Block(
+			jen.Return().Qual("fmt", "Errorf").Call(jen.Lit("invalid value %q for field %q"), v.Clone(), jen.Lit(path)),
+		),
+	)
+}
+
+// paramName returns a Go identifier suitable for a function parameter.
+func paramName(name string) string {
+	first, rest, _ := strings.Cut(name, "_")
+	first = naming.GoName(first)
+	if first == strings.ToUpper(first) {
+		// Initialisms are lower-cased as a whole, e.g. "id" and "url"
+		first = strings.ToLower(first)
+	} else {
+		first = strings.ToLower(first[:1]) + first[1:]
+	}
+	name = first + naming.GoName(rest)
+	if token.IsKeyword(name) {
+		name += "_"
+	}
 	return name
 }
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"go/format"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-varlink/varlinkdef"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+func generateString(t *testing.T, gen *generator, raw string) string {
+	iface, err := varlinkdef.ReadString(raw)
+	if err != nil {
+		t.Fatalf("varlinkdef.ReadString() = %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := gen.generate(iface, "test").Render(&buf); err != nil {
+		t.Fatalf("Render() = %v", err)
+	}
+	return buf.String()
+}
+
+// goldenGenerators holds the generator options for golden packages, by
+// package name. Packages not listed use generator{genError: true}.
+var goldenGenerators = map[string]generator{
+	"peer":    {genError: true, context: true},
+	"oneway":  {genError: true, oneway: methodSetFlag{"Notify": true}},
+	"stream":  {genError: true, more: methodSetFlag{"Monitor": true}},
+	"timeout": {genError: true, timeout: 50 * time.Millisecond},
+}
+
+// TestGenerate_golden checks that the generated packages under internal/ are
+// up-to-date. Run with -update to regenerate them.
+func TestGenerate_golden(t *testing.T) {
+	filenames, err := filepath.Glob("internal/*/*.varlink")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, filename := range filenames {
+		name := strings.TrimSuffix(filepath.Base(filename), ".varlink")
+		t.Run(name, func(t *testing.T) {
+			raw, err := os.ReadFile(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			iface, err := varlinkdef.ReadString(string(raw))
+			if err != nil {
+				t.Fatalf("varlinkdef.ReadString() = %v", err)
+			}
+
+			pkgName := filepath.Base(filepath.Dir(filename))
+			gen, ok := goldenGenerators[pkgName]
+			if !ok {
+				gen = generator{genError: true}
+			}
+			var buf bytes.Buffer
+			if err := gen.generate(iface, pkgName).Render(&buf); err != nil {
+				t.Fatalf("Render() = %v", err)
+			}
+			got := buf.String()
+
+			goldenFilename := strings.TrimSuffix(filename, ".varlink") + ".go"
+			if *update {
+				if err := os.WriteFile(goldenFilename, []byte(got), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			want, err := os.ReadFile(goldenFilename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != string(want) {
+				t.Errorf("generated code doesn't match %v:\n%v", goldenFilename, got)
+			}
+		})
+	}
+}
+
+func TestGenerate_extraTags(t *testing.T) {
+	const raw = `interface org.example.tags
+
+method Get(client_id: string) -> (tylium_level: ?int)
+`
+
+	gen := generator{extraTags: tagFlag{"db": "{name}", "validate": "required"}}
+	got := generateString(t, &gen, raw)
+
+	for _, want := range []string{
+		"`db:\"client_id\" json:\"client_id\" validate:\"required\"`",
+		"`db:\"tylium_level\" json:\"tylium_level,omitempty\" validate:\"required\"`",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("generated code doesn't contain %v:\n%v", want, got)
+		}
+	}
+}
+
+func TestTagFlag_Set(t *testing.T) {
+	tf := make(tagFlag)
+	if err := tf.Set("db={name}"); err != nil {
+		t.Errorf("Set(db) = %v", err)
+	}
+	for _, s := range []string{"json={name}", "json=", "db", "=x"} {
+		if err := tf.Set(s); err == nil {
+			t.Errorf("Set(%q) = nil, want an error", s)
+		}
+	}
+	if _, ok := tf["json"]; ok {
+		t.Errorf("Set() stored a json tag template")
+	}
+}
+
+func TestGenerate_errorConstructors(t *testing.T) {
+	const raw = `interface org.example.errors
+
+method Ping() -> ()
+
+error Busy ()
+error InvalidRange (min: int, max: ?int, type: string)
+`
+
+	gen := generator{genError: true}
+	got := generateString(t, &gen, raw)
+
+	for _, want := range []string{
+		"func NewBusyError() *BusyError {\n\treturn &BusyError{}\n}",
+		"func NewInvalidRangeError(max *int, min int, type_ string) *InvalidRangeError {\n\treturn &InvalidRangeError{\n\t\tMax:  max,\n\t\tMin:  min,\n\t\tType: type_,\n\t}\n}",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("generated code doesn't contain %v:\n%v", want, got)
+		}
+	}
+}
+
+func TestWriteOutput_stdout(t *testing.T) {
+	iface, err := varlinkdef.ReadString(`interface org.example.stdout
+
+method Ping(ping: string) -> (pong: string)
+`)
+	if err != nil {
+		t.Fatalf("varlinkdef.ReadString() = %v", err)
+	}
+
+	gen := generator{genError: true}
+	var buf bytes.Buffer
+	if err := writeOutput(gen.generate(iface, "stdout"), "-", &buf); err != nil {
+		t.Fatalf("writeOutput() = %v", err)
+	}
+
+	got := buf.Bytes()
+	if !bytes.HasPrefix(got, []byte("// Code generated by go-varlink/varlinkgen. DO NOT EDIT.\n")) {
+		t.Errorf("output doesn't start with the generated code header:\n%s", got)
+	}
+	formatted, err := format.Source(got)
+	if err != nil {
+		t.Fatalf("format.Source() = %v", err)
+	}
+	if !bytes.Equal(got, formatted) {
+		t.Errorf("output is not gofmt-clean:\n%s", got)
+	}
+}
+
+func TestGenerate_fieldNames(t *testing.T) {
+	const raw = `interface org.example.names
+
+method Get(client_id: string, URL: string) -> (tylium_level: int)
+
+error NotFound (id: string, client_id: string)
+`
+
+	gen := generator{}
+	got := generateString(t, &gen, raw)
+
+	// The json tag always carries the exact wire name
+	for _, want := range []string{
+		"ClientID string `json:\"client_id\"`",
+		"URL      string `json:\"URL\"`",
+		"TyliumLevel int `json:\"tylium_level\"`",
+		"func NewNotFoundError(clientID string, id string) *NotFoundError",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("generated code doesn't contain %v:\n%v", want, got)
+		}
+	}
+}
+
+func TestGenerate_docs(t *testing.T) {
+	const raw = `# Interface doc.
+interface org.example.docs
+
+# Type doc,
+#
+# with an empty line.
+type T (x: int)
+
+# Method doc.
+method WithDoc() -> ()
+
+method NoDoc() -> ()
+`
+
+	gen := generator{}
+	got := generateString(t, &gen, raw)
+
+	for _, want := range []string{
+		"// Package test implements the org.example.docs Varlink interface.\n//\n// Interface doc.\npackage test\n",
+		"// Type doc,\n//\n// with an empty line.\ntype T struct",
+		"// Method doc.\ntype WithDocIn struct",
+		"// Method doc.\ntype WithDocOut struct",
+		"// Method doc.\nfunc (c Client) WithDoc(",
+		"\t// Method doc.\n\tWithDoc(*WithDocIn)",
+		"// Interface doc.\ntype Handler struct",
+		"\n\ntype NoDocIn struct",
+		"}\nfunc (c Client) NoDoc(",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("generated code doesn't contain %q:\n%v", want, got)
+		}
+	}
+}
+
+func TestCheckPackageName(t *testing.T) {
+	tests := []struct {
+		name  string
+		valid bool
+	}{
+		{"calcapi", true},
+		{"ftl", true},
+		{"_ftl", true},
+		{"v2", true},
+		{"", false},
+		{"_", false},
+		{"org.example.calc", false},
+		{"calc-api", false},
+		{"2calc", false},
+		{"func", false},
+	}
+	for _, tc := range tests {
+		err := checkPackageName(tc.name)
+		if tc.valid && err != nil {
+			t.Errorf("checkPackageName(%q) = %v", tc.name, err)
+		} else if !tc.valid && err == nil {
+			t.Errorf("checkPackageName(%q) = nil, want an error", tc.name)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package varlink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// Dial connects to a Varlink service at the specified address.
+//
+// The following address forms are supported:
+//
+//   - "unix:/run/org.example.ftl" for a Unix socket
+//   - "unix:@org.example.ftl" for an abstract Unix socket (Linux only)
+//   - "tcp:127.0.0.1:12345" for a TCP connection
+//   - "exec:/usr/bin/org.example.ftl" to start a service and communicate with
+//     it over its standard input and output, see ServeStdio
+//
+// Parameters following a semicolon in Unix addresses (e.g. ";mode=0666")
+// only apply to listeners and are ignored.
+func Dial(address string) (*Client, error) {
+	scheme, rest, ok := strings.Cut(address, ":")
+	if !ok {
+		return nil, fmt.Errorf("varlink: invalid address %q: missing scheme", address)
+	}
+
+	var (
+		conn net.Conn
+		err  error
+	)
+	switch scheme {
+	case "unix":
+		path, _, _ := strings.Cut(rest, ";")
+		// The net package handles the leading "@" of abstract sockets
+		conn, err = net.Dial("unix", path)
+	case "tcp":
+		conn, err = net.Dial("tcp", rest)
+	case "exec":
+		conn, err = dialExec(context.Background(), rest)
+	default:
+		return nil, fmt.Errorf("varlink: unsupported address scheme %q", scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn), nil
+}
+
+// AddressEnv is the name of the environment variable holding the address of a
+// Varlink service, read by AddressFromEnv.
+const AddressEnv = "VARLINK_ADDRESS"
+
+// AddressFromEnv returns the Varlink address configured in the environment.
+//
+// If the VARLINK_ADDRESS environment variable is set, its value is returned.
+// Otherwise, if the process has been socket-activated (see
+// ListenActivation), the address "fd://3" of the first file descriptor passed
+// by the service manager is returned. Such an address cannot be passed to
+// Dial: the listener must be obtained via ListenActivation. An error is
+// returned if no address is configured.
+func AddressFromEnv() (string, error) {
+	if addr := os.Getenv(AddressEnv); addr != "" {
+		return addr, nil
+	}
+	n, err := listenFDs()
+	if err != nil {
+		return "", err
+	} else if n > 0 {
+		return fmt.Sprintf("fd://%v", listenFDsStart), nil
+	}
+	return "", fmt.Errorf("varlink: no address found in environment: %v is not set", AddressEnv)
+}
+
+// DialExec starts a Varlink service and connects to it over its standard
+// input and output, see ServeStdio. The service is killed when ctx is done or
+// when the client is closed.
+//
+// If the service exits with an error while the client is in use, pending and
+// future calls fail with an error containing its exit status.
+func DialExec(ctx context.Context, name string, args ...string) (*Client, error) {
+	conn, err := dialExec(ctx, name, args...)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn), nil
+}
+
+// execConn is a connection to a child process over its standard input and
+// output.
+type execConn struct {
+	stdioConn
+	cmd *exec.Cmd
+
+	// Closed when the child process has exited, waitErr is then set
+	done    chan struct{}
+	waitErr error
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+func dialExec(ctx context.Context, name string, args ...string) (net.Conn, error) {
+	stdinR, stdinW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	stdoutR, stdoutW, err := os.Pipe()
+	if err != nil {
+		stdinR.Close()
+		stdinW.Close()
+		return nil, err
+	}
+
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdin = stdinR
+	cmd.Stdout = stdoutW
+	cmd.Stderr = os.Stderr
+	err = cmd.Start()
+	// The child process has its own copy of these
+	stdinR.Close()
+	stdoutW.Close()
+	if err != nil {
+		stdinW.Close()
+		stdoutR.Close()
+		return nil, fmt.Errorf("varlink: failed to start %q: %v", name, err)
+	}
+
+	c := &execConn{
+		stdioConn: stdioConn{r: stdoutR, w: stdinW},
+		cmd:       cmd,
+		done:      make(chan struct{}),
+	}
+	go func() {
+		c.waitErr = cmd.Wait()
+		close(c.done)
+	}()
+	return c, nil
+}
+
+// Read reads from the standard output of the child process. When it reaches
+// EOF, Read waits for the child process to exit and returns its exit status
+// if it failed.
+func (c *execConn) Read(b []byte) (int, error) {
+	n, err := c.stdioConn.Read(b)
+	if err == io.EOF {
+		<-c.done
+		if c.waitErr != nil {
+			err = fmt.Errorf("varlink: service %q exited: %v", c.cmd.Path, c.waitErr)
+		}
+	}
+	return n, mapClosedErr(err)
+}
+
+func (c *execConn) Write(b []byte) (int, error) {
+	n, err := c.stdioConn.Write(b)
+	return n, mapClosedErr(err)
+}
+
+// Close closes the pipes and terminates the child process.
+func (c *execConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.closeErr = c.stdioConn.Close()
+		c.cmd.Process.Kill()
+		<-c.done
+	})
+	return c.closeErr
+}
+
+// mapClosedErr replaces os.ErrClosed with net.ErrClosed, so that an execConn
+// closed locally is handled like a network connection.
+func mapClosedErr(err error) error {
+	if errors.Is(err, os.ErrClosed) {
+		return net.ErrClosed
+	}
+	return err
+}
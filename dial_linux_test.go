@@ -0,0 +1,26 @@
+package varlink_test
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/emersion/go-varlink"
+)
+
+func TestDial_abstract(t *testing.T) {
+	name := fmt.Sprintf("@go-varlink-test-%v", os.Getpid())
+	ln, err := net.Listen("unix", name)
+	if err != nil {
+		t.Fatalf("net.Listen() = %v", err)
+	}
+	serveTest(t, ln)
+
+	c, err := varlink.Dial("unix:" + name)
+	if err != nil {
+		t.Fatalf("Dial() = %v", err)
+	}
+	defer c.Close()
+	checkPing(t, c)
+}
@@ -0,0 +1,258 @@
+package varlink_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-varlink"
+)
+
+const (
+	// execServiceEnv is set when the test binary is started as a service
+	// over its standard input and output.
+	execServiceEnv = "GO_VARLINK_TEST_EXEC_SERVICE"
+	// activationServiceEnv is set when the test binary is started as a
+	// socket-activated service.
+	activationServiceEnv = "GO_VARLINK_TEST_ACTIVATION_SERVICE"
+	// execDelayEnv holds a duration the service started over its standard
+	// input and output waits for before serving.
+	execDelayEnv = "GO_VARLINK_TEST_EXEC_DELAY"
+)
+
+func TestMain(m *testing.M) {
+	if os.Getenv(execServiceEnv) != "" {
+		if delay, err := time.ParseDuration(os.Getenv(execDelayEnv)); err == nil {
+			time.Sleep(delay)
+		}
+		err := varlink.ServeStdio(handlerFunc(func(call *varlink.ServerCall, req *varlink.ServerRequest) error {
+			if req.Method == "org.example.test.Exit" {
+				os.Exit(3)
+			}
+			return call.CloseWithReply(map[string]string{"method": req.Method})
+		}))
+		if err != nil {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if os.Getenv(activationServiceEnv) != "" {
+		serveActivation()
+	}
+	os.Exit(m.Run())
+}
+
+func checkPing(t *testing.T, c *varlink.Client) {
+	t.Helper()
+	var out struct{ Method string }
+	if err := c.Do("org.example.test.Ping", nil, &out); err != nil {
+		t.Fatalf("Do() = %v", err)
+	} else if out.Method != "org.example.test.Ping" {
+		t.Errorf("Do() = %q, want %q", out.Method, "org.example.test.Ping")
+	}
+}
+
+func serveTest(t *testing.T, ln net.Listener) {
+	t.Cleanup(func() { ln.Close() })
+	srv := varlink.NewServer()
+	srv.Handler = handlerFunc(func(call *varlink.ServerCall, req *varlink.ServerRequest) error {
+		return call.CloseWithReply(map[string]string{"method": req.Method})
+	})
+	go srv.Serve(ln)
+}
+
+func TestDial(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.sock")
+	unixLn, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("net.Listen() = %v", err)
+	}
+	serveTest(t, unixLn)
+
+	tcpLn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen() = %v", err)
+	}
+	serveTest(t, tcpLn)
+
+	for _, addr := range []string{
+		"unix:" + path,
+		"unix:" + path + ";mode=0666",
+		"tcp:" + tcpLn.Addr().String(),
+	} {
+		t.Run(addr, func(t *testing.T) {
+			c, err := varlink.Dial(addr)
+			if err != nil {
+				t.Fatalf("Dial() = %v", err)
+			}
+			defer c.Close()
+			checkPing(t, c)
+		})
+	}
+}
+
+func TestDial_exec(t *testing.T) {
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatalf("os.Executable() = %v", err)
+	}
+	t.Setenv(execServiceEnv, "1")
+
+	c, err := varlink.Dial("exec:" + exe)
+	if err != nil {
+		t.Fatalf("Dial() = %v", err)
+	}
+	defer c.Close()
+	checkPing(t, c)
+}
+
+func TestDialExec(t *testing.T) {
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatalf("os.Executable() = %v", err)
+	}
+	t.Setenv(execServiceEnv, "1")
+
+	c, err := varlink.DialExec(context.Background(), exe)
+	if err != nil {
+		t.Fatalf("DialExec() = %v", err)
+	}
+	defer c.Close()
+	checkPing(t, c)
+
+	// The service dies in the middle of the call
+	err = c.Do("org.example.test.Exit", nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "exit status 3") {
+		t.Errorf("Do(Exit) = %v, want an error with the exit status", err)
+	}
+	if err := c.Do("org.example.test.Ping", nil, nil); err == nil {
+		t.Errorf("Do(Ping) after exit = nil, want an error")
+	}
+}
+
+func TestDialExec_close(t *testing.T) {
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatalf("os.Executable() = %v", err)
+	}
+	t.Setenv(execServiceEnv, "1")
+
+	c, err := varlink.DialExec(context.Background(), exe)
+	if err != nil {
+		t.Fatalf("DialExec() = %v", err)
+	}
+	checkPing(t, c)
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("Close() = %v", err)
+	}
+	if err := c.Do("org.example.test.Ping", nil, nil); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("Do() after Close() = %v, want %v", err, net.ErrClosed)
+	}
+}
+
+func TestClient_WaitReady(t *testing.T) {
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatalf("os.Executable() = %v", err)
+	}
+	t.Setenv(execServiceEnv, "1")
+	const delay = 100 * time.Millisecond
+	t.Setenv(execDelayEnv, delay.String())
+
+	c, err := varlink.DialExec(context.Background(), exe)
+	if err != nil {
+		t.Fatalf("DialExec() = %v", err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), delay/10)
+	defer cancel()
+	if err := c.WaitReady(ctx); err != context.DeadlineExceeded {
+		t.Errorf("WaitReady() = %v, want %v while the service is starting", err, context.DeadlineExceeded)
+	}
+
+	start := time.Now()
+	if err := c.WaitReady(context.Background()); err != nil {
+		t.Fatalf("WaitReady() = %v", err)
+	}
+	if d := time.Since(start); d < delay/2 {
+		t.Errorf("WaitReady() returned after %v, want about %v", d, delay)
+	}
+	checkPing(t, c)
+
+	// Once ready, WaitReady returns immediately
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if err := c.WaitReady(ctx); err != nil {
+		t.Errorf("WaitReady() = %v after the service is ready", err)
+	}
+}
+
+func TestClient_WaitReady_socket(t *testing.T) {
+	c := newTestClient(t, handlerFunc(func(call *varlink.ServerCall, req *varlink.ServerRequest) error {
+		return call.CloseWithReply(nil)
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := c.WaitReady(ctx); err != nil {
+		t.Errorf("WaitReady() = %v, want nil for a connected client", err)
+	}
+}
+
+func TestDialExec_notFound(t *testing.T) {
+	if c, err := varlink.DialExec(context.Background(), filepath.Join(t.TempDir(), "missing")); err == nil {
+		c.Close()
+		t.Errorf("DialExec() = nil, want an error")
+	}
+}
+
+func TestDial_invalid(t *testing.T) {
+	for _, addr := range []string{"", "/run/org.example.ftl", "http://localhost", "vsock:1:2"} {
+		if c, err := varlink.Dial(addr); err == nil {
+			c.Close()
+			t.Errorf("Dial(%q) = nil, want an error", addr)
+		}
+	}
+}
+
+func TestAddressFromEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    string
+		wantErr bool
+	}{
+		{"unset", nil, "", true},
+		{"address", map[string]string{varlink.AddressEnv: "unix:/run/org.example.ftl"}, "unix:/run/org.example.ftl", false},
+		{"activation", map[string]string{"LISTEN_PID": strconv.Itoa(os.Getpid()), "LISTEN_FDS": "1"}, "fd://3", false},
+		{"otherProcess", map[string]string{"LISTEN_PID": "1", "LISTEN_FDS": "1"}, "", true},
+		{"both", map[string]string{
+			varlink.AddressEnv: "tcp:127.0.0.1:12345",
+			"LISTEN_PID":       strconv.Itoa(os.Getpid()),
+			"LISTEN_FDS":       "1",
+		}, "tcp:127.0.0.1:12345", false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, k := range []string{varlink.AddressEnv, "LISTEN_PID", "LISTEN_FDS"} {
+				t.Setenv(k, tc.env[k])
+			}
+			addr, err := varlink.AddressFromEnv()
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("AddressFromEnv() = %q, want an error", addr)
+				}
+			} else if err != nil || addr != tc.want {
+				t.Errorf("AddressFromEnv() = %q, %v, want %q", addr, err, tc.want)
+			}
+		})
+	}
+}
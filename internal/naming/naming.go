@@ -0,0 +1,37 @@
+// Package naming maps Varlink names to Go identifiers. It is shared by
+// varlinkdef and varlinkgen so that both agree on generated names.
+package naming
+
+import (
+	"strings"
+)
+
+// commonInitialisms is the set of initialisms written in upper-case in Go
+// identifiers, from golint.
+var commonInitialisms = map[string]bool{
+	"acl": true, "api": true, "ascii": true, "cpu": true, "css": true,
+	"dns": true, "eof": true, "guid": true, "html": true, "http": true,
+	"https": true, "id": true, "ip": true, "json": true, "lhs": true,
+	"qps": true, "ram": true, "rhs": true, "rpc": true, "sla": true,
+	"smtp": true, "sql": true, "ssh": true, "tcp": true, "tls": true,
+	"ttl": true, "udp": true, "ui": true, "uid": true, "uuid": true,
+	"uri": true, "url": true, "utf8": true, "vm": true, "xml": true,
+	"xmpp": true, "xsrf": true, "xss": true,
+}
+
+// GoName converts a Varlink name to an exported Go identifier. Underscores
+// are removed and the letter following each of them is upper-cased.
+// Lower-case words which are common initialisms are upper-cased, so that
+// "client_id" becomes "ClientID". Other letters are kept as-is, so acronyms
+// such as "URL" are preserved. Varlink names can't start with an underscore.
+func GoName(name string) string {
+	words := strings.Split(name, "_")
+	for i, w := range words {
+		if commonInitialisms[w] {
+			words[i] = strings.ToUpper(w)
+		} else {
+			words[i] = strings.Title(w)
+		}
+	}
+	return strings.Join(words, "")
+}
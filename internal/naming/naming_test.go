@@ -0,0 +1,30 @@
+package naming
+
+import (
+	"testing"
+)
+
+func TestGoName(t *testing.T) {
+	tests := []struct {
+		name, want string
+	}{
+		{"tylium_level", "TyliumLevel"},
+		{"client_id", "ClientID"},
+		{"id", "ID"},
+		{"Id", "Id"},
+		{"URL", "URL"},
+		{"url", "URL"},
+		{"http_url", "HTTPURL"},
+		{"identity", "Identity"},
+		{"base_URL", "BaseURL"},
+		{"camelCase", "CamelCase"},
+		{"PascalCase", "PascalCase"},
+		{"a_b_c", "ABC"},
+		{"v2", "V2"},
+	}
+	for _, tc := range tests {
+		if got := GoName(tc.name); got != tc.want {
+			t.Errorf("GoName(%q) = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
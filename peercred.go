@@ -0,0 +1,36 @@
+package varlink
+
+import (
+	"context"
+	"errors"
+)
+
+// Ucred contains the credentials of the process on the other end of a
+// connection.
+type Ucred struct {
+	PID int32
+	UID uint32
+	GID uint32
+}
+
+// ErrNoPeerCredentials is returned by ServerCall.PeerCredentials when the
+// credentials of the peer cannot be retrieved for the connection's transport.
+var ErrNoPeerCredentials = errors.New("varlink: peer credentials not available")
+
+// PeerCredentials returns the credentials of the client process.
+//
+// This is only supported for Unix sockets on Linux, where the credentials are
+// retrieved with SO_PEERCRED: they are the ones of the client process at the
+// time it connected. ErrNoPeerCredentials is returned for other transports.
+func (call *ServerCall) PeerCredentials() (*Ucred, error) {
+	return peerCredentials(call.conn.Conn)
+}
+
+type peerContextKey struct{}
+
+// PeerFromContext returns the credentials of the client process stored in a
+// context returned by ServerCall.Context.
+func PeerFromContext(ctx context.Context) (*Ucred, bool) {
+	cred, ok := ctx.Value(peerContextKey{}).(*Ucred)
+	return cred, ok
+}
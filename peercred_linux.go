@@ -0,0 +1,29 @@
+package varlink
+
+import (
+	"net"
+	"syscall"
+)
+
+func peerCredentials(c net.Conn) (*Ucred, error) {
+	unixConn, ok := c.(*net.UnixConn)
+	if !ok {
+		return nil, ErrNoPeerCredentials
+	}
+	rawConn, err := unixConn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	var cred *syscall.Ucred
+	var credErr error
+	err = rawConn.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return nil, err
+	} else if credErr != nil {
+		return nil, credErr
+	}
+	return &Ucred{PID: cred.Pid, UID: cred.Uid, GID: cred.Gid}, nil
+}
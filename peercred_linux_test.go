@@ -0,0 +1,77 @@
+package varlink_test
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/emersion/go-varlink"
+)
+
+func TestServerCall_PeerCredentials(t *testing.T) {
+	ln, err := net.Listen("unix", filepath.Join(t.TempDir(), "test.sock"))
+	if err != nil {
+		t.Fatalf("net.Listen() = %v", err)
+	}
+	defer ln.Close()
+
+	srv := varlink.NewServer()
+	srv.Handler = handlerFunc(func(call *varlink.ServerCall, req *varlink.ServerRequest) error {
+		cred, err := call.PeerCredentials()
+		if err != nil {
+			return err
+		}
+		return call.CloseWithReply(cred)
+	})
+	go srv.Serve(ln)
+
+	conn, err := net.Dial("unix", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("net.Dial() = %v", err)
+	}
+	c := varlink.NewClient(conn)
+	defer c.Close()
+
+	var cred varlink.Ucred
+	if err := c.Do("org.example.test.WhoAmI", nil, &cred); err != nil {
+		t.Fatalf("Do() = %v", err)
+	}
+	want := varlink.Ucred{PID: int32(os.Getpid()), UID: uint32(os.Getuid()), GID: uint32(os.Getgid())}
+	if cred != want {
+		t.Errorf("PeerCredentials() = %+v, want %+v", cred, want)
+	}
+}
+
+func TestPeerFromContext(t *testing.T) {
+	ln, err := net.Listen("unix", filepath.Join(t.TempDir(), "test.sock"))
+	if err != nil {
+		t.Fatalf("net.Listen() = %v", err)
+	}
+	defer ln.Close()
+
+	srv := varlink.NewServer()
+	srv.Handler = handlerFunc(func(call *varlink.ServerCall, req *varlink.ServerRequest) error {
+		cred, ok := varlink.PeerFromContext(call.Context())
+		if !ok {
+			return fmt.Errorf("missing peer credentials")
+		}
+		return call.CloseWithReply(cred)
+	})
+	go srv.Serve(ln)
+
+	conn, err := net.Dial("unix", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("net.Dial() = %v", err)
+	}
+	c := varlink.NewClient(conn)
+	defer c.Close()
+
+	var cred varlink.Ucred
+	if err := c.Do("org.example.test.WhoAmI", nil, &cred); err != nil {
+		t.Fatalf("Do() = %v", err)
+	} else if cred.UID != uint32(os.Getuid()) {
+		t.Errorf("PeerFromContext() UID = %v, want %v", cred.UID, os.Getuid())
+	}
+}
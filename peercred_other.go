@@ -0,0 +1,11 @@
+//go:build !linux
+
+package varlink
+
+import (
+	"net"
+)
+
+func peerCredentials(c net.Conn) (*Ucred, error) {
+	return nil, ErrNoPeerCredentials
+}
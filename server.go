@@ -1,12 +1,22 @@
 package varlink
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/emersion/go-varlink/varlinkdef"
 )
 
 // ServerRequest is a request coming from a Varlink client.
@@ -18,6 +28,74 @@ type ServerRequest struct {
 	Upgrade    bool            `json:"upgrade,omitempty"`
 }
 
+// Validate checks that the request is well-formed: the method name must be
+// fully qualified and flags must not conflict.
+func (req *ServerRequest) Validate() error {
+	i := strings.LastIndexByte(req.Method, '.')
+	if i < 0 || !isInterfaceName(req.Method[:i]) || !isMemberName(req.Method[i+1:]) {
+		return &invalidRequestError{"method", fmt.Sprintf("invalid method name %q", req.Method)}
+	}
+	if req.More && req.Oneway {
+		return &invalidRequestError{"more", "more and oneway are mutually exclusive"}
+	}
+	if req.More && req.Upgrade {
+		return &invalidRequestError{"more", "more and upgrade are mutually exclusive"}
+	}
+	if req.Oneway && req.Upgrade {
+		return &invalidRequestError{"oneway", "oneway and upgrade are mutually exclusive"}
+	}
+	return nil
+}
+
+func isInterfaceName(s string) bool {
+	parts := strings.Split(s, ".")
+	if len(parts) < 2 || !isAlpha(s[0]) {
+		return false
+	}
+	for _, part := range parts {
+		if part == "" || part[0] == '-' || part[len(part)-1] == '-' {
+			return false
+		}
+		for i := 0; i < len(part); i++ {
+			if !isAlphaNum(part[i]) && part[i] != '-' {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func isMemberName(s string) bool {
+	if s == "" || s[0] < 'A' || s[0] > 'Z' {
+		return false
+	}
+	for i := 1; i < len(s); i++ {
+		if !isAlphaNum(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isAlphaNum(ch byte) bool {
+	return isAlpha(ch) || (ch >= '0' && ch <= '9')
+}
+
+func isAlpha(ch byte) bool {
+	return (ch >= 'A' && ch <= 'Z') || (ch >= 'a' && ch <= 'z')
+}
+
+// invalidRequestError is returned by ServerRequest.Validate.
+type invalidRequestError struct {
+	// Name of the offending request field
+	parameter string
+	msg       string
+}
+
+func (err *invalidRequestError) Error() string {
+	return "varlink: " + err.msg
+}
+
 type serverReply struct {
 	Parameters interface{} `json:"parameters"`
 	Continues  bool        `json:"continues,omitempty"`
@@ -25,6 +103,9 @@ type serverReply struct {
 }
 
 // ServerError is an error to be sent to a Varlink client.
+//
+// Parameters must marshal to a JSON object, or be nil. Otherwise, the error
+// is not sent and the connection is closed.
 type ServerError struct {
 	Name       string
 	Parameters interface{}
@@ -35,14 +116,37 @@ func (err *ServerError) Error() string {
 	return fmt.Sprintf("varlink: server call failed: %v", err.Name)
 }
 
+// marshalParameters marshals v and checks that the result is a JSON object.
+// A nil v is marshaled to an empty object.
+func marshalParameters(v interface{}) (json.RawMessage, error) {
+	if v == nil {
+		return json.RawMessage("{}"), nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 || b[0] != '{' {
+		if bytes.Equal(b, []byte("null")) {
+			return json.RawMessage("{}"), nil
+		}
+		return nil, fmt.Errorf("parameters must be a JSON object, got %s", b)
+	}
+	return b, nil
+}
+
 // ServerCall represents an in-progress Varlink method call.
 //
 // Handlers may call Reply any number of times, then they must end the call
-// with CloseWithReply.
+// with CloseWithReply, Upgrade or by returning a *ServerError. Exactly one
+// final reply is sent per call: once CloseWithReply has been called, handlers
+// must not return a *ServerError.
 type ServerCall struct {
-	conn *conn
-	req  *ServerRequest
-	done bool
+	conn     *conn
+	ctx      context.Context
+	req      *ServerRequest
+	done     bool
+	upgraded bool
 }
 
 func (call *ServerCall) reply(reply *serverReply) error {
@@ -50,7 +154,15 @@ func (call *ServerCall) reply(reply *serverReply) error {
 		if !call.req.More {
 			return fmt.Errorf("varlink: ServerCall.Reply called for a request without More set")
 		}
-	} else {
+	}
+	if reply.Error == "" {
+		params, err := marshalParameters(reply.Parameters)
+		if err != nil {
+			return fmt.Errorf("varlink: invalid reply: %v", err)
+		}
+		reply.Parameters = params
+	}
+	if !reply.Continues {
 		if call.done {
 			return fmt.Errorf("varlink: ServerCall.CloseWithReply called twice")
 		}
@@ -65,6 +177,15 @@ func (call *ServerCall) reply(reply *serverReply) error {
 // Reply sends a non-final reply.
 //
 // This can only be used if ServerRequest.More is set to true.
+//
+// Parameters must marshal to a JSON object, or be nil. Varlink replies cannot
+// be bare arrays or scalars: methods returning a list need to wrap it in a
+// named field, e.g. "(items: []Item)".
+//
+// Replies are not buffered: each reply is written to the connection before
+// Reply returns, so that clients receive it immediately, e.g. when streaming
+// events as they happen. This costs one write per reply, which can matter
+// for methods sending many small replies in a burst.
 func (call *ServerCall) Reply(parameters interface{}) error {
 	return call.reply(&serverReply{
 		Parameters: parameters,
@@ -75,20 +196,271 @@ func (call *ServerCall) Reply(parameters interface{}) error {
 // CloseWithReply sends a final reply and closes the call.
 //
 // No more replies may be sent.
+//
+// Parameters are marshaled with encoding/json: map keys are sorted and struct
+// fields are written in declaration order, so a given value always produces
+// the same bytes on the wire.
+//
+// As with Reply, parameters must marshal to a JSON object, or be nil.
 func (call *ServerCall) CloseWithReply(parameters interface{}) error {
 	return call.reply(&serverReply{Parameters: parameters})
 }
 
+// Context returns the context of the call. If the credentials of the client
+// process are available (see PeerCredentials), they can be retrieved from the
+// context with PeerFromContext.
+func (call *ServerCall) Context() context.Context {
+	return call.ctx
+}
+
+// Upgrade sends a final reply and takes over the connection, for a request
+// with ServerRequest.Upgrade set. The server stops processing Varlink messages
+// on the connection.
+//
+// The returned bufio.Reader must be used to read from the connection, since it
+// may contain bytes already received by the server. The handler owns the
+// connection afterwards and is responsible for closing it.
+//
+// As with CloseWithReply, parameters must marshal to a JSON object, or be nil.
+func (call *ServerCall) Upgrade(parameters interface{}) (net.Conn, *bufio.Reader, error) {
+	if !call.req.Upgrade {
+		return nil, nil, fmt.Errorf("varlink: ServerCall.Upgrade called for a request without Upgrade set")
+	}
+	if err := call.reply(&serverReply{Parameters: parameters}); err != nil {
+		return nil, nil, err
+	}
+	call.upgraded = true
+	// Don't leave the deadline of the last message behind
+	if call.conn.writeTimeout > 0 {
+		if err := call.conn.SetWriteDeadline(time.Time{}); err != nil {
+			return nil, nil, err
+		}
+	}
+	return call.conn.Conn, call.conn.br, nil
+}
+
 // A Handler processes Varlink requests.
 type Handler interface {
 	HandleVarlink(call *ServerCall, req *ServerRequest) error
 }
 
+// MethodHandler is a function handling Varlink requests.
+type MethodHandler func(call *ServerCall, req *ServerRequest) error
+
+// HandleVarlink implements Handler.
+func (f MethodHandler) HandleVarlink(call *ServerCall, req *ServerRequest) error {
+	return f(call, req)
+}
+
+// MethodMux is a Handler dispatching requests by fully qualified method name
+// (e.g. "org.example.ftl.Jump") with a single map lookup.
+//
+// Requests for unknown methods are rejected with an
+// org.varlink.service.MethodNotFound error.
+type MethodMux map[string]MethodHandler
+
+// HandleVarlink implements Handler.
+func (mux MethodMux) HandleVarlink(call *ServerCall, req *ServerRequest) error {
+	h, ok := mux[req.Method]
+	if !ok {
+		return &ServerError{
+			Name:       "org.varlink.service.MethodNotFound",
+			Parameters: map[string]string{"method": req.Method},
+		}
+	}
+	return h(call, req)
+}
+
+// Merge adds the methods of other to mux. An error is returned if a method is
+// already present in mux, in which case mux is left unchanged.
+//
+// Merge must not be called while mux is used to serve requests.
+func (mux MethodMux) Merge(other MethodMux) error {
+	for method := range other {
+		if _, ok := mux[method]; ok {
+			return fmt.Errorf("varlink: duplicate method %q", method)
+		}
+	}
+	for method, h := range other {
+		mux[method] = h
+	}
+	return nil
+}
+
+// RequireInterface returns a handler which only dispatches calls to methods
+// defined in iface to h. Calls to other methods are rejected with an
+// org.varlink.service.MethodNotFound error.
+func RequireInterface(iface *varlinkdef.Interface, h Handler) Handler {
+	return &requireInterfaceHandler{iface: iface, h: h}
+}
+
+type requireInterfaceHandler struct {
+	iface *varlinkdef.Interface
+	h     Handler
+}
+
+func (h *requireInterfaceHandler) HandleVarlink(call *ServerCall, req *ServerRequest) error {
+	name := strings.TrimPrefix(req.Method, h.iface.Name+".")
+	if _, ok := h.iface.Methods[name]; !ok || name == req.Method {
+		return &ServerError{
+			Name:       "org.varlink.service.MethodNotFound",
+			Parameters: map[string]string{"method": req.Method},
+		}
+	}
+	return h.h.HandleVarlink(call, req)
+}
+
 // Server is a Varlink server.
 //
 // The Handler field must be set to a Varlink request handler.
+//
+// Requests received on a single connection are dispatched sequentially, in
+// the order they were sent. Connections are read through a buffer, so
+// pipelined requests already sent by a client are parsed without extra reads
+// from the underlying connection.
 type Server struct {
 	Handler Handler
+
+	// If positive, TCP keep-alive is enabled on accepted TCP connections with
+	// the specified period. If negative, TCP keep-alive is disabled. Ignored
+	// for other transports.
+	KeepAlive time.Duration
+
+	// If non-nil, a copy of each message received and sent is written to
+	// Tap, for debugging purposes. See ClientOptions.Tap for the format. Tap
+	// is shared by all connections and may be written to from multiple
+	// goroutines.
+	Tap io.Writer
+
+	// If true, errors returned by handlers which aren't a *ServerError are
+	// reported to the client as a Varlink error instead of closing the
+	// connection. The error parameters are a JSON object with a "code" field
+	// set to "internal" and a "message" field containing the error string.
+	ReportInternalErrors bool
+	// Name of the Varlink error used by ReportInternalErrors. If empty,
+	// DefaultInternalErrorName is used.
+	InternalErrorName string
+	// If true, the "message" field of internal errors is replaced with a
+	// generic text. Error strings may leak implementation details to
+	// clients. The original error is logged.
+	RedactInternalErrors bool
+
+	// If true, requests which cannot be decoded are logged and skipped
+	// instead of closing the connection. No reply is sent for a
+	// skipped request, so clients pipelining requests may get out of sync.
+	// This can mask client bugs and should be used with care.
+	SkipMalformedRequests bool
+
+	// If non-nil, called for each request before the handler. If it returns
+	// a non-nil error, the handler is not called and the request fails with
+	// an org.varlink.service.PermissionDenied error. The error returned by
+	// Authorize is logged but not sent to the client.
+	Authorize func(call *ServerCall, req *ServerRequest) error
+
+	// If non-nil, called with the recovered value when a handler panics,
+	// instead of logging it. The call then fails with an internal error (see
+	// InternalErrorName), and the connection is left open.
+	PanicHandler func(v interface{})
+
+	// Options applied to accepted connections.
+	ConnOptions ConnOptions
+
+	// If non-zero, at most MaxConcurrentHandlers handlers run at the same
+	// time across all connections. Calls exceeding the limit wait for a
+	// running handler to return. Must not be changed after Serve is called.
+	MaxConcurrentHandlers int
+
+	// If non-zero, at most MaxConns connections are served at the same
+	// time. Connections accepted while the limit is reached are closed
+	// immediately, without reading any request.
+	MaxConns int
+
+	// If non-zero, connections are closed once the total size of the
+	// messages received on them exceeds MaxConnBytes. This bounds the
+	// amount of data a single long-lived connection can send, see also
+	// ConnOptions.MaxMessageSize.
+	MaxConnBytes int64
+
+	serving    atomic.Int32
+	handlersMu sync.Mutex
+	handlers   chan struct{}
+
+	inShutdown atomic.Bool
+	mutex      sync.Mutex
+	listeners  map[net.Listener]struct{}
+	conns      map[*conn]struct{}
+	connsWG    sync.WaitGroup
+}
+
+// ErrServerClosed is returned by Server.Serve and Server.ServeContext after a
+// call to Server.Shutdown.
+var ErrServerClosed = errors.New("varlink: server closed")
+
+// DefaultInternalErrorName is the default Varlink error name used to report
+// internal errors, see Server.ReportInternalErrors.
+const DefaultInternalErrorName = "org.varlink.service.InternalError"
+
+type internalErrorParams struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (srv *Server) internalError(err error) *ServerError {
+	name := srv.InternalErrorName
+	if name == "" {
+		name = DefaultInternalErrorName
+	}
+	msg := err.Error()
+	if srv.RedactInternalErrors {
+		msg = "internal error"
+	}
+	return &ServerError{
+		Name:       name,
+		Parameters: &internalErrorParams{Code: "internal", Message: msg},
+	}
+}
+
+// acquireHandler waits until a handler can run, see MaxConcurrentHandlers.
+// The returned function must be called when the handler returns.
+func (srv *Server) acquireHandler() (release func()) {
+	if srv.MaxConcurrentHandlers <= 0 {
+		return func() {}
+	}
+
+	srv.handlersMu.Lock()
+	if srv.handlers == nil {
+		srv.handlers = make(chan struct{}, srv.MaxConcurrentHandlers)
+	}
+	sem := srv.handlers
+	srv.handlersMu.Unlock()
+
+	sem <- struct{}{}
+	return func() { <-sem }
+}
+
+// handle calls the handler, recovering from panics. A panic is reported to
+// the client as an internal error if the final reply hasn't been sent yet.
+func (srv *Server) handle(call *ServerCall, req *ServerRequest) (err error) {
+	release := srv.acquireHandler()
+	defer release()
+
+	defer func() {
+		v := recover()
+		if v == nil {
+			return
+		}
+		if srv.PanicHandler != nil {
+			srv.PanicHandler(v)
+		} else {
+			log.Printf("varlink: panic handling call to %q: %v\n%s", req.Method, v, debug.Stack())
+		}
+		err = fmt.Errorf("panic: %v", v)
+		if !call.done {
+			err = srv.internalError(err)
+		}
+	}()
+
+	return srv.Handler.HandleVarlink(call, req)
 }
 
 // NewServer creates a new Varlink server.
@@ -97,48 +469,293 @@ func NewServer() *Server {
 }
 
 // Serve listens for connections.
+//
+// After Shutdown has been called, Serve returns ErrServerClosed.
 func (srv *Server) Serve(ln net.Listener) error {
+	if !srv.trackListener(ln) {
+		return ErrServerClosed
+	}
+	defer srv.untrackListener(ln)
+
+	srv.serving.Add(1)
+	defer srv.serving.Add(-1)
+
 	for {
 		conn, err := ln.Accept()
 		if err != nil {
+			if srv.inShutdown.Load() {
+				return ErrServerClosed
+			}
+			return err
+		}
+		if srv.KeepAlive != 0 {
+			if err := setKeepAlive(conn, srv.KeepAlive); err != nil {
+				log.Printf("varlink: failed to set TCP keep-alive: %v", err)
+			}
+		}
+		vc := newConn(conn, &srv.ConnOptions)
+		vc.tap = srv.Tap
+		if err := srv.trackConn(vc); err == errTooManyConns {
+			log.Printf("varlink: closing connection from %v: %v", conn.RemoteAddr(), err)
+			conn.Close()
+			continue
+		} else if err != nil {
+			conn.Close()
 			return err
 		}
 		go func() {
-			if err := srv.serveConn(newConn(conn)); err != nil {
+			defer srv.untrackConn(vc)
+			if err := srv.serveConn(vc); err != nil {
 				log.Printf("varlink: serving connection: %v", err)
 			}
 		}()
 	}
 }
 
+// Shutdown gracefully shuts down the server. Listeners are closed, idle
+// connections are closed, and connections with a call in progress are closed
+// once the call is complete. Shutdown then waits for all connections to be
+// closed, or for ctx to be done, in which case ctx.Err() is returned.
+//
+// Requests pipelined after the call in progress are not processed. A request
+// which has only been partially received when Shutdown is called is
+// discarded as well.
+//
+// Connections handed off to a handler via ServerCall.Upgrade are no longer
+// tracked by the server: Shutdown neither closes nor waits for them.
+//
+// Once Shutdown has been called, the server cannot be reused.
+func (srv *Server) Shutdown(ctx context.Context) error {
+	srv.mutex.Lock()
+	srv.inShutdown.Store(true)
+	listeners := srv.listeners
+	srv.listeners = nil
+	conns := make([]*conn, 0, len(srv.conns))
+	for c := range srv.conns {
+		conns = append(conns, c)
+	}
+	srv.mutex.Unlock()
+
+	for ln := range listeners {
+		ln.Close()
+	}
+	// Interrupt connections waiting for a request. Connections handling a
+	// call notice the shutdown when the handler returns.
+	for _, c := range conns {
+		c.SetReadDeadline(time.Now())
+	}
+
+	done := make(chan struct{})
+	go func() {
+		srv.connsWG.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (srv *Server) trackListener(ln net.Listener) bool {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	if srv.inShutdown.Load() {
+		return false
+	}
+	if srv.listeners == nil {
+		srv.listeners = make(map[net.Listener]struct{})
+	}
+	srv.listeners[ln] = struct{}{}
+	return true
+}
+
+func (srv *Server) untrackListener(ln net.Listener) {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	delete(srv.listeners, ln)
+}
+
+var errTooManyConns = errors.New("too many connections")
+
+func (srv *Server) trackConn(c *conn) error {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	if srv.inShutdown.Load() {
+		return ErrServerClosed
+	}
+	if srv.MaxConns > 0 && len(srv.conns) >= srv.MaxConns {
+		return errTooManyConns
+	}
+	if srv.conns == nil {
+		srv.conns = make(map[*conn]struct{})
+	}
+	srv.conns[c] = struct{}{}
+	srv.connsWG.Add(1)
+	return nil
+}
+
+func (srv *Server) untrackConn(c *conn) {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	delete(srv.conns, c)
+	srv.connsWG.Done()
+}
+
+// ActiveConns returns the number of connections currently served by Serve.
+func (srv *Server) ActiveConns() int {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	return len(srv.conns)
+}
+
+// Healthy reports whether the server is accepting connections, i.e. whether
+// a call to Serve or ServeContext is running.
+//
+// This only reflects the state of the server itself. Health checks performed
+// over Varlink should call org.varlink.service.GetInfo, which every service
+// implements and which is cheap to serve.
+func (srv *Server) Healthy() bool {
+	return srv.serving.Load() > 0
+}
+
+// ServeContext is similar to Serve, but stops accepting connections when ctx
+// is done. The listener is closed and ctx.Err() is returned. Connections
+// which have already been accepted are left running.
+func (srv *Server) ServeContext(ctx context.Context, ln net.Listener) error {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			ln.Close()
+		case <-done:
+		}
+	}()
+
+	err := srv.Serve(ln)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
+
+func setKeepAlive(conn net.Conn, period time.Duration) error {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return nil
+	}
+	if period < 0 {
+		return tcpConn.SetKeepAlive(false)
+	}
+	if err := tcpConn.SetKeepAlive(true); err != nil {
+		return err
+	}
+	return tcpConn.SetKeepAlivePeriod(period)
+}
+
+// readRequest reads the next request from conn, enforcing MaxConnBytes.
+func (srv *Server) readRequest(conn *conn, req *ServerRequest) error {
+	err := conn.readMessage(req)
+	if srv.MaxConnBytes > 0 && conn.bytesRead > srv.MaxConnBytes {
+		return fmt.Errorf("varlink: connection exceeded limit of %v bytes", srv.MaxConnBytes)
+	}
+	return err
+}
+
 func (srv *Server) serveConn(conn *conn) error {
-	defer conn.Close()
+	upgraded := false
+	defer func() {
+		if !upgraded {
+			conn.Close()
+		}
+	}()
+
+	ctx := context.Background()
+	if cred, err := peerCredentials(conn.Conn); err == nil {
+		ctx = context.WithValue(ctx, peerContextKey{}, cred)
+	}
 
 	for {
+		if srv.inShutdown.Load() {
+			return nil
+		}
+
 		var req ServerRequest
-		if err := conn.readMessage(&req); err == io.EOF {
+		var malformedErr *malformedMessageError
+		if err := srv.readRequest(conn, &req); err == io.EOF || (err != nil && srv.inShutdown.Load()) {
 			return nil
+		} else if srv.SkipMalformedRequests && errors.As(err, &malformedErr) {
+			log.Printf("varlink: skipping malformed request: %v", err)
+			continue
 		} else if err != nil {
 			return fmt.Errorf("reading request: %v", err)
 		}
 
-		if req.Upgrade {
-			return fmt.Errorf("varlink: connection upgrades not implemented")
+		if err := req.Validate(); err != nil {
+			if req.Oneway {
+				// The client doesn't expect a reply
+				log.Printf("varlink: ignoring invalid oneway request: %v", err)
+				continue
+			}
+			invalidErr := err.(*invalidRequestError)
+			if err := conn.writeMessage(&serverReply{
+				Error:      "org.varlink.service.InvalidParameter",
+				Parameters: map[string]string{"parameter": invalidErr.parameter},
+			}); err != nil {
+				return fmt.Errorf("writing error: %v", err)
+			}
+			continue
 		}
 
 		call := &ServerCall{
 			conn: conn,
+			ctx:  ctx,
 			req:  &req,
 		}
-		err := srv.Handler.HandleVarlink(call, &req)
+		var err error
+		if srv.Authorize != nil {
+			if authErr := srv.Authorize(call, &req); authErr != nil {
+				log.Printf("varlink: denied call to %q: %v", req.Method, authErr)
+				err = &ServerError{Name: "org.varlink.service.PermissionDenied"}
+			}
+		}
+		if err == nil {
+			err = srv.handle(call, &req)
+		}
+		if call.upgraded {
+			// The handler owns the connection now
+			if err != nil {
+				log.Printf("varlink: handling call to %q after upgrade: %v", req.Method, err)
+			}
+			upgraded = true
+			return nil
+		}
 		var verr *ServerError
-		if errors.As(err, &verr) {
+		if err != nil && !call.done && srv.ReportInternalErrors && !errors.As(err, &verr) {
+			log.Printf("varlink: handling call to %q: %v", req.Method, err)
+			err = srv.internalError(err)
+		}
+		if err != nil && call.done {
+			// The final reply has already been sent, there is no way to
+			// report the error to the client anymore
+			if errors.As(err, &verr) {
+				return fmt.Errorf("varlink: handler for %q returned error %q after ServerCall.CloseWithReply", req.Method, verr.Name)
+			}
+			log.Printf("varlink: handling call after reply: %v", err)
+			continue
+		} else if errors.As(err, &verr) {
 			if req.Oneway {
 				continue
 			}
+			params, err := marshalParameters(verr.Parameters)
+			if err != nil {
+				return fmt.Errorf("varlink: invalid parameters for error %q: %v", verr.Name, err)
+			}
 			if err := call.reply(&serverReply{
 				Error:      verr.Name,
-				Parameters: verr.Parameters,
+				Parameters: params,
 			}); err != nil {
 				return fmt.Errorf("writing error: %v", err)
 			}
@@ -146,8 +763,11 @@ func (srv *Server) serveConn(conn *conn) error {
 			return fmt.Errorf("handling call: %v", err)
 		}
 
+		if req.Upgrade && call.done && err == nil {
+			return fmt.Errorf("varlink: handler for %q replied to an upgrade request without calling ServerCall.Upgrade", req.Method)
+		}
 		if !req.Oneway && !call.done {
-			return fmt.Errorf("varlink: ServerCall.CloseWithReply not called")
+			return fmt.Errorf("varlink: handler for %q returned without calling ServerCall.CloseWithReply", req.Method)
 		}
 	}
 }
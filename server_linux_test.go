@@ -0,0 +1,101 @@
+package varlink
+
+import (
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+type handlerFunc func(call *ServerCall, req *ServerRequest) error
+
+func (f handlerFunc) HandleVarlink(call *ServerCall, req *ServerRequest) error {
+	return f(call, req)
+}
+
+// getsockoptInt reads an integer socket option of the connection serving call.
+func getsockoptInt(call *ServerCall, level, opt int) (int, error) {
+	rawConn, err := call.conn.Conn.(*net.TCPConn).SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var v int
+	var sockErr error
+	err = rawConn.Control(func(fd uintptr) {
+		v, sockErr = syscall.GetsockoptInt(int(fd), level, opt)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return v, sockErr
+}
+
+func TestServer_KeepAlive(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen() = %v", err)
+	}
+	defer ln.Close()
+
+	idleCh := make(chan int, 1)
+	srv := NewServer()
+	srv.KeepAlive = 42 * time.Second
+	srv.Handler = handlerFunc(func(call *ServerCall, req *ServerRequest) error {
+		idle, err := getsockoptInt(call, syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE)
+		if err != nil {
+			return err
+		}
+		idleCh <- idle
+		return call.CloseWithReply(nil)
+	})
+	go srv.Serve(ln)
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("net.Dial() = %v", err)
+	}
+	c := NewClient(conn)
+	defer c.Close()
+
+	if err := c.Do("org.example.test.Ping", nil, nil); err != nil {
+		t.Fatalf("Do() = %v", err)
+	}
+	if idle := <-idleCh; idle != 42 {
+		t.Errorf("TCP_KEEPIDLE = %v, want 42", idle)
+	}
+}
+
+func TestServer_KeepAlive_disabled(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen() = %v", err)
+	}
+	defer ln.Close()
+
+	enabledCh := make(chan int, 1)
+	srv := NewServer()
+	srv.KeepAlive = -1
+	srv.Handler = handlerFunc(func(call *ServerCall, req *ServerRequest) error {
+		enabled, err := getsockoptInt(call, syscall.SOL_SOCKET, syscall.SO_KEEPALIVE)
+		if err != nil {
+			return err
+		}
+		enabledCh <- enabled
+		return call.CloseWithReply(nil)
+	})
+	go srv.Serve(ln)
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("net.Dial() = %v", err)
+	}
+	c := NewClient(conn)
+	defer c.Close()
+
+	if err := c.Do("org.example.test.Ping", nil, nil); err != nil {
+		t.Fatalf("Do() = %v", err)
+	}
+	if enabled := <-enabledCh; enabled != 0 {
+		t.Errorf("SO_KEEPALIVE = %v, want 0", enabled)
+	}
+}
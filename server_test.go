@@ -0,0 +1,959 @@
+package varlink_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-varlink"
+	"github.com/emersion/go-varlink/varlinkdef"
+)
+
+func TestServer_replyThenError(t *testing.T) {
+	c := newTestClient(t, handlerFunc(func(call *varlink.ServerCall, req *varlink.ServerRequest) error {
+		if err := call.CloseWithReply(nil); err != nil {
+			return err
+		}
+		switch req.Method {
+		case "org.example.test.Error":
+			return errors.New("something went wrong")
+		case "org.example.test.VarlinkError":
+			return &varlink.ServerError{Name: "org.example.test.Failed"}
+		}
+		return nil
+	}))
+
+	// A plain error after the reply is logged, the connection stays usable
+	if err := c.Do("org.example.test.Error", nil, nil); err != nil {
+		t.Fatalf("Do(Error) = %v", err)
+	}
+	if err := c.Do("org.example.test.Ping", nil, nil); err != nil {
+		t.Fatalf("Do(Ping) = %v", err)
+	}
+
+	// A Varlink error after the reply is a handler bug: the reply is
+	// delivered, then the connection is closed
+	if err := c.Do("org.example.test.VarlinkError", nil, nil); err != nil {
+		t.Fatalf("Do(VarlinkError) = %v", err)
+	}
+	if err := c.Do("org.example.test.Ping", nil, nil); err == nil {
+		t.Errorf("Do(Ping) succeeded after handler bug")
+	}
+}
+
+func BenchmarkServer_pipelined(b *testing.B) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatalf("net.Listen() = %v", err)
+	}
+	defer ln.Close()
+
+	srv := varlink.NewServer()
+	srv.Handler = handlerFunc(func(call *varlink.ServerCall, req *varlink.ServerRequest) error {
+		return call.CloseWithReply(nil)
+	})
+	go srv.Serve(ln)
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		b.Fatalf("net.Dial() = %v", err)
+	}
+	c := varlink.NewClient(conn)
+	defer c.Close()
+
+	const batchSize = 16
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i += batchSize {
+		calls := make([]*varlink.ClientCall, 0, batchSize)
+		for j := 0; j < batchSize; j++ {
+			cc, err := c.DoMore("org.example.test.Ping", nil)
+			if err != nil {
+				b.Fatalf("DoMore() = %v", err)
+			}
+			calls = append(calls, cc)
+		}
+		for _, cc := range calls {
+			if err := cc.Next(nil); err != nil {
+				b.Fatalf("Next() = %v", err)
+			}
+		}
+	}
+}
+
+func TestRequireInterface(t *testing.T) {
+	iface, err := varlinkdef.ReadString(`interface org.example.test
+
+method Ping() -> ()
+`)
+	if err != nil {
+		t.Fatalf("ReadString() = %v", err)
+	}
+
+	c := newTestClient(t, varlink.RequireInterface(iface, handlerFunc(func(call *varlink.ServerCall, req *varlink.ServerRequest) error {
+		return call.CloseWithReply(nil)
+	})))
+
+	if err := c.Do("org.example.test.Ping", nil, nil); err != nil {
+		t.Errorf("Do(Ping) = %v", err)
+	}
+
+	for _, method := range []string{"org.example.test.Pong", "org.example.other.Ping", "org.example.test.Ping.Ping"} {
+		err := c.Do(method, nil, nil)
+		if verr, ok := err.(*varlink.ClientError); !ok || verr.Name != "org.varlink.service.MethodNotFound" {
+			t.Errorf("Do(%q) = %v, want MethodNotFound", method, err)
+		}
+	}
+}
+
+func TestServer_deterministicReply(t *testing.T) {
+	c := newTestClient(t, handlerFunc(func(call *varlink.ServerCall, req *varlink.ServerRequest) error {
+		return call.CloseWithReply(map[string]interface{}{
+			"zulu":  1,
+			"alpha": map[string]int{"b": 2, "a": 1, "c": 3},
+			"mike":  []string{"x", "y"},
+			"kilo":  struct{ B, A int }{2, 1},
+		})
+	}))
+
+	var first json.RawMessage
+	for i := 0; i < 10; i++ {
+		var out json.RawMessage
+		if err := c.Do("org.example.test.Get", nil, &out); err != nil {
+			t.Fatalf("Do() = %v", err)
+		}
+		if i == 0 {
+			first = out
+		} else if !bytes.Equal(out, first) {
+			t.Fatalf("reply %v = %s, want %s", i, out, first)
+		}
+	}
+}
+
+func TestServerRequest_Validate(t *testing.T) {
+	tests := []struct {
+		name  string
+		req   varlink.ServerRequest
+		valid bool
+	}{
+		{"simple", varlink.ServerRequest{Method: "org.example.ftl.Jump"}, true},
+		{"more", varlink.ServerRequest{Method: "org.example.ftl.Monitor", More: true}, true},
+		{"oneway", varlink.ServerRequest{Method: "org.example.ftl.Jump", Oneway: true}, true},
+		{"upgrade", varlink.ServerRequest{Method: "org.example.ftl.Jump", Upgrade: true}, true},
+		{"dashes", varlink.ServerRequest{Method: "org.example-test.v2.Jump"}, true},
+		{"empty", varlink.ServerRequest{}, false},
+		{"noInterface", varlink.ServerRequest{Method: "Jump"}, false},
+		{"singleLabelInterface", varlink.ServerRequest{Method: "org.Jump"}, false},
+		{"lowercaseMember", varlink.ServerRequest{Method: "org.example.ftl.jump"}, false},
+		{"emptyLabel", varlink.ServerRequest{Method: "org..ftl.Jump"}, false},
+		{"trailingDash", varlink.ServerRequest{Method: "org.example-.Jump"}, false},
+		{"onewayMore", varlink.ServerRequest{Method: "org.example.ftl.Jump", Oneway: true, More: true}, false},
+		{"upgradeMore", varlink.ServerRequest{Method: "org.example.ftl.Jump", Upgrade: true, More: true}, false},
+		{"onewayUpgrade", varlink.ServerRequest{Method: "org.example.ftl.Jump", Oneway: true, Upgrade: true}, false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.req.Validate()
+			if tc.valid && err != nil {
+				t.Errorf("Validate() = %v", err)
+			} else if !tc.valid && err == nil {
+				t.Errorf("Validate() = nil, want an error")
+			}
+		})
+	}
+}
+
+func TestMethodMux(t *testing.T) {
+	c := newTestClient(t, varlink.MethodMux{
+		"org.example.test.Ping": func(call *varlink.ServerCall, req *varlink.ServerRequest) error {
+			return call.CloseWithReply(nil)
+		},
+		"org.example.other.Echo": func(call *varlink.ServerCall, req *varlink.ServerRequest) error {
+			return call.CloseWithReply(req.Parameters)
+		},
+	})
+
+	if err := c.Do("org.example.test.Ping", nil, nil); err != nil {
+		t.Errorf("Do(Ping) = %v", err)
+	}
+
+	var out struct{ Text string }
+	if err := c.Do("org.example.other.Echo", map[string]string{"text": "hi"}, &out); err != nil {
+		t.Errorf("Do(Echo) = %v", err)
+	} else if out.Text != "hi" {
+		t.Errorf("Do(Echo) = %q, want %q", out.Text, "hi")
+	}
+
+	err := c.Do("org.example.test.Echo", nil, nil)
+	if verr, ok := err.(*varlink.ClientError); !ok || verr.Name != "org.varlink.service.MethodNotFound" {
+		t.Errorf("Do(unknown) = %v, want MethodNotFound", err)
+	}
+}
+
+func TestMethodMux_Merge(t *testing.T) {
+	reply := func(name string) varlink.MethodHandler {
+		return func(call *varlink.ServerCall, req *varlink.ServerRequest) error {
+			return call.CloseWithReply(map[string]string{"from": name})
+		}
+	}
+
+	mux := varlink.MethodMux{"org.example.a.Get": reply("a")}
+	if err := mux.Merge(varlink.MethodMux{"org.example.b.Get": reply("b")}); err != nil {
+		t.Fatalf("Merge() = %v", err)
+	}
+	if err := mux.Merge(varlink.MethodMux{
+		"org.example.c.Get": reply("c"),
+		"org.example.a.Get": reply("c"),
+	}); err == nil {
+		t.Errorf("Merge() = nil, want an error for a duplicate method")
+	}
+
+	c := newTestClient(t, mux)
+	for _, name := range []string{"a", "b"} {
+		var out struct{ From string }
+		if err := c.Do("org.example."+name+".Get", nil, &out); err != nil {
+			t.Errorf("Do(%v) = %v", name, err)
+		} else if out.From != name {
+			t.Errorf("Do(%v) = %q, want %q", name, out.From, name)
+		}
+	}
+
+	// A failed merge leaves the mux unchanged
+	if err := c.Do("org.example.c.Get", nil, nil); err == nil {
+		t.Errorf("Do(c) succeeded after a failed merge")
+	}
+}
+
+const benchmarkMethodCount = 32
+
+func benchmarkMethodName(i int) string {
+	return fmt.Sprintf("org.example.bench.Method%v", i)
+}
+
+type switchHandler struct{}
+
+func (switchHandler) HandleVarlink(call *varlink.ServerCall, req *varlink.ServerRequest) error {
+	// Mimics the dispatch performed by generated handlers
+	switch req.Method {
+	case "org.example.bench.Method0":
+		return nil
+	case "org.example.bench.Method1":
+		return nil
+	case "org.example.bench.Method2":
+		return nil
+	case "org.example.bench.Method3":
+		return nil
+	case "org.example.bench.Method4":
+		return nil
+	case "org.example.bench.Method5":
+		return nil
+	case "org.example.bench.Method6":
+		return nil
+	case "org.example.bench.Method7":
+		return nil
+	case "org.example.bench.Method8":
+		return nil
+	case "org.example.bench.Method9":
+		return nil
+	case "org.example.bench.Method10":
+		return nil
+	case "org.example.bench.Method11":
+		return nil
+	case "org.example.bench.Method12":
+		return nil
+	case "org.example.bench.Method13":
+		return nil
+	case "org.example.bench.Method14":
+		return nil
+	case "org.example.bench.Method15":
+		return nil
+	case "org.example.bench.Method16":
+		return nil
+	case "org.example.bench.Method17":
+		return nil
+	case "org.example.bench.Method18":
+		return nil
+	case "org.example.bench.Method19":
+		return nil
+	case "org.example.bench.Method20":
+		return nil
+	case "org.example.bench.Method21":
+		return nil
+	case "org.example.bench.Method22":
+		return nil
+	case "org.example.bench.Method23":
+		return nil
+	case "org.example.bench.Method24":
+		return nil
+	case "org.example.bench.Method25":
+		return nil
+	case "org.example.bench.Method26":
+		return nil
+	case "org.example.bench.Method27":
+		return nil
+	case "org.example.bench.Method28":
+		return nil
+	case "org.example.bench.Method29":
+		return nil
+	case "org.example.bench.Method30":
+		return nil
+	case "org.example.bench.Method31":
+		return nil
+	default:
+		return &varlink.ServerError{Name: "org.varlink.service.MethodNotFound"}
+	}
+}
+
+func benchmarkDispatch(b *testing.B, h varlink.Handler) {
+	reqs := make([]*varlink.ServerRequest, benchmarkMethodCount)
+	for i := range reqs {
+		reqs[i] = &varlink.ServerRequest{Method: benchmarkMethodName(i)}
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := h.HandleVarlink(nil, reqs[i%len(reqs)]); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDispatch_switch(b *testing.B) {
+	benchmarkDispatch(b, switchHandler{})
+}
+
+func BenchmarkDispatch_methodMux(b *testing.B) {
+	mux := make(varlink.MethodMux)
+	for i := 0; i < benchmarkMethodCount; i++ {
+		mux[benchmarkMethodName(i)] = func(call *varlink.ServerCall, req *varlink.ServerRequest) error {
+			return nil
+		}
+	}
+	benchmarkDispatch(b, mux)
+}
+
+func TestServer_ServeContext(t *testing.T) {
+	ln := newTestListener(t)
+
+	srv := varlink.NewServer()
+	srv.Handler = handlerFunc(func(call *varlink.ServerCall, req *varlink.ServerRequest) error {
+		return call.CloseWithReply(nil)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- srv.ServeContext(ctx, ln)
+	}()
+
+	c := varlink.NewClient(dialTestConn(t, ln))
+	t.Cleanup(func() { c.Close() })
+	if err := c.Do("org.example.test.Ping", nil, nil); err != nil {
+		t.Fatalf("Do() = %v", err)
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Errorf("ServeContext() = %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("ServeContext() did not return after cancellation")
+	}
+
+	// Connections accepted before cancellation keep working
+	if err := c.Do("org.example.test.Ping", nil, nil); err != nil {
+		t.Errorf("Do() after cancellation = %v", err)
+	}
+}
+
+func TestServer_ReportInternalErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		configure func(srv *varlink.Server)
+		errName   string
+		message   string
+	}{
+		{"default", func(srv *varlink.Server) {
+			srv.ReportInternalErrors = true
+		}, varlink.DefaultInternalErrorName, "disk on fire"},
+		{"customName", func(srv *varlink.Server) {
+			srv.ReportInternalErrors = true
+			srv.InternalErrorName = "org.example.test.Internal"
+		}, "org.example.test.Internal", "disk on fire"},
+		{"redacted", func(srv *varlink.Server) {
+			srv.ReportInternalErrors = true
+			srv.RedactInternalErrors = true
+		}, varlink.DefaultInternalErrorName, "internal error"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newTestClient(t, handlerFunc(func(call *varlink.ServerCall, req *varlink.ServerRequest) error {
+				if req.Method == "org.example.test.Fail" {
+					return errors.New("disk on fire")
+				}
+				return call.CloseWithReply(nil)
+			}), tc.configure)
+
+			err := c.Do("org.example.test.Fail", nil, nil)
+			verr, ok := err.(*varlink.ClientError)
+			if !ok {
+				t.Fatalf("Do() = %v, want a *varlink.ClientError", err)
+			}
+			var params struct {
+				Code    string
+				Message string
+			}
+			if err := json.Unmarshal(verr.Parameters, &params); err != nil {
+				t.Fatalf("json.Unmarshal() = %v", err)
+			}
+			if verr.Name != tc.errName || params.Code != "internal" || params.Message != tc.message {
+				t.Errorf("Do() = %v with parameters %s, want %v with message %q", verr.Name, verr.Parameters, tc.errName, tc.message)
+			}
+
+			// The connection is still usable
+			if err := c.Do("org.example.test.Ping", nil, nil); err != nil {
+				t.Errorf("Do(Ping) = %v", err)
+			}
+		})
+	}
+}
+
+func TestServer_SkipMalformedRequests(t *testing.T) {
+	for _, skip := range []bool{false, true} {
+		t.Run(fmt.Sprintf("skip=%v", skip), func(t *testing.T) {
+			conn := newTestConn(t, handlerFunc(func(call *varlink.ServerCall, req *varlink.ServerRequest) error {
+				return call.CloseWithReply(map[string]string{"method": req.Method})
+			}), func(srv *varlink.Server) {
+				srv.SkipMalformedRequests = skip
+			})
+
+			frames := []string{
+				`{"method": "org.example.test.Ping"`,
+				`{"method": "org.example.test.Ping"}`,
+			}
+			for _, frame := range frames {
+				if _, err := conn.Write([]byte(frame + "\x00")); err != nil {
+					t.Fatalf("Write() = %v", err)
+				}
+			}
+
+			b, err := bufio.NewReader(conn).ReadBytes(0)
+			if skip {
+				want := `{"parameters":{"method":"org.example.test.Ping"}}` + "\x00"
+				if err != nil || string(b) != want {
+					t.Errorf("ReadBytes() = %q, %v, want %q", b, err, want)
+				}
+			} else if err == nil {
+				// The connection is closed, possibly with a reset since the
+				// server didn't read the remaining requests
+				t.Errorf("ReadBytes() = %q, want an error", b)
+			}
+		})
+	}
+}
+
+func TestServer_invalidRequest(t *testing.T) {
+	c := newTestClient(t, handlerFunc(func(call *varlink.ServerCall, req *varlink.ServerRequest) error {
+		return call.CloseWithReply(nil)
+	}))
+
+	err := c.Do("Ping", nil, nil)
+	verr, ok := err.(*varlink.ClientError)
+	if !ok || verr.Name != "org.varlink.service.InvalidParameter" || string(verr.Parameters) != `{"parameter":"method"}` {
+		t.Errorf("Do() = %v, want InvalidParameter for method", err)
+	}
+
+	// The connection is kept open
+	if err := c.Do("org.example.test.Ping", nil, nil); err != nil {
+		t.Errorf("Do() = %v", err)
+	}
+}
+
+func TestServerError_parameters(t *testing.T) {
+	tests := []struct {
+		name   string
+		params interface{}
+		valid  bool
+		want   string
+	}{
+		{"nil", nil, true, `{}`},
+		{"nilMap", map[string]string(nil), true, `{}`},
+		{"struct", struct {
+			Field string `json:"field"`
+		}{"x"}, true, `{"field":"x"}`},
+		{"string", "some string", false, ""},
+		{"array", []string{"a"}, false, ""},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newTestClient(t, handlerFunc(func(call *varlink.ServerCall, req *varlink.ServerRequest) error {
+				return &varlink.ServerError{Name: "org.example.test.Failed", Parameters: tc.params}
+			}))
+
+			err := c.Do("org.example.test.Fail", nil, nil)
+			verr, ok := err.(*varlink.ClientError)
+			if tc.valid {
+				if !ok || string(verr.Parameters) != tc.want {
+					t.Errorf("Do() = %#v, want parameters %v", err, tc.want)
+				}
+			} else if ok {
+				t.Errorf("Do() = %#v, want the connection to be closed", err)
+			}
+		})
+	}
+}
+
+func TestServerCall_arrayReply(t *testing.T) {
+	type load struct {
+		CPU int `json:"cpu"`
+	}
+
+	c := newTestClient(t, handlerFunc(func(call *varlink.ServerCall, req *varlink.ServerRequest) error {
+		loads := []load{{1}, {2}}
+		if err := call.CloseWithReply(loads); err == nil {
+			return errors.New("CloseWithReply() accepted an array")
+		}
+		// The call is still open, reply with the array wrapped in a field
+		return call.CloseWithReply(map[string]interface{}{"loads": loads})
+	}))
+
+	var out struct {
+		Loads []load `json:"loads"`
+	}
+	if err := c.Do("org.example.test.GetLoads", nil, &out); err != nil {
+		t.Fatalf("Do() = %v", err)
+	}
+	if len(out.Loads) != 2 || out.Loads[1].CPU != 2 {
+		t.Errorf("Do() = %+v", out)
+	}
+}
+
+func TestServer_Authorize(t *testing.T) {
+	called := make(chan string, 10)
+	c := newTestClient(t, handlerFunc(func(call *varlink.ServerCall, req *varlink.ServerRequest) error {
+		called <- req.Method
+		return call.CloseWithReply(nil)
+	}), func(srv *varlink.Server) {
+		srv.Authorize = func(call *varlink.ServerCall, req *varlink.ServerRequest) error {
+			if req.Method == "org.example.test.Reboot" {
+				return errors.New("not allowed")
+			}
+			return nil
+		}
+	})
+
+	if err := c.Do("org.example.test.Ping", nil, nil); err != nil {
+		t.Errorf("Do(Ping) = %v", err)
+	}
+	err := c.Do("org.example.test.Reboot", nil, nil)
+	if verr, ok := err.(*varlink.ClientError); !ok || verr.Name != "org.varlink.service.PermissionDenied" {
+		t.Errorf("Do(Reboot) = %v, want PermissionDenied", err)
+	}
+
+	close(called)
+	var methods []string
+	for method := range called {
+		methods = append(methods, method)
+	}
+	if len(methods) != 1 || methods[0] != "org.example.test.Ping" {
+		t.Errorf("handler called for %v, want only Ping", methods)
+	}
+}
+
+func TestServerCall_Reply_unbuffered(t *testing.T) {
+	next := make(chan struct{})
+	c := newTestClient(t, handlerFunc(func(call *varlink.ServerCall, req *varlink.ServerRequest) error {
+		for i := 0; i < 3; i++ {
+			if err := call.Reply(map[string]int{"index": i}); err != nil {
+				return err
+			}
+			// Don't produce the next reply until the client has received
+			// this one
+			<-next
+		}
+		return call.CloseWithReply(nil)
+	}))
+
+	cc, err := c.DoMore("org.example.test.Tail", nil)
+	if err != nil {
+		t.Fatalf("DoMore() = %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		var out struct{ Index int }
+		if err := cc.Next(&out); err != nil {
+			t.Fatalf("Next() = %v", err)
+		} else if out.Index != i {
+			t.Errorf("Next() = %v, want %v", out.Index, i)
+		}
+		next <- struct{}{}
+	}
+	if err := cc.Next(nil); err != nil {
+		t.Fatalf("Next() = %v", err)
+	}
+}
+
+func TestServer_MaxConcurrentHandlers(t *testing.T) {
+	const (
+		max     = 2
+		clients = 8
+	)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen() = %v", err)
+	}
+	defer ln.Close()
+
+	var running, peak atomic.Int32
+	srv := &varlink.Server{MaxConcurrentHandlers: max}
+	srv.Handler = handlerFunc(func(call *varlink.ServerCall, req *varlink.ServerRequest) error {
+		n := running.Add(1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		running.Add(-1)
+		return call.CloseWithReply(nil)
+	})
+	go srv.Serve(ln)
+
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatalf("net.Dial() = %v", err)
+		}
+		c := varlink.NewClient(conn)
+		defer c.Close()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 4; j++ {
+				if err := c.Do("org.example.test.Work", nil, nil); err != nil {
+					t.Errorf("Do() = %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if p := peak.Load(); p > max {
+		t.Errorf("%v handlers ran concurrently, want at most %v", p, max)
+	} else if p < max {
+		t.Logf("only %v handlers ran concurrently", p)
+	}
+}
+
+func TestServer_MaxConnBytes(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen() = %v", err)
+	}
+	defer ln.Close()
+
+	srv := &varlink.Server{MaxConnBytes: 256}
+	srv.Handler = handlerFunc(func(call *varlink.ServerCall, req *varlink.ServerRequest) error {
+		return call.CloseWithReply(nil)
+	})
+	go srv.Serve(ln)
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("net.Dial() = %v", err)
+	}
+	c := varlink.NewClient(conn)
+	defer c.Close()
+
+	// Each request is a little less than 64 bytes: the first few ones fit
+	// in the budget, then the connection is closed
+	var n int
+	for n = 0; n < 16; n++ {
+		if err := c.Do("org.example.test.Ping", nil, nil); err != nil {
+			break
+		}
+	}
+	if n == 0 || n >= 16 {
+		t.Errorf("connection closed after %v calls, want a few calls to succeed", n)
+	}
+}
+
+func TestServer_Shutdown(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen() = %v", err)
+	}
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	srv := varlink.NewServer()
+	srv.Handler = handlerFunc(func(call *varlink.ServerCall, req *varlink.ServerRequest) error {
+		if req.Method == "org.example.test.Wait" {
+			close(started)
+			<-release
+		}
+		return call.CloseWithReply(nil)
+	})
+	serveDone := make(chan error, 1)
+	go func() {
+		serveDone <- srv.Serve(ln)
+	}()
+
+	dial := func() *varlink.Client {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatalf("net.Dial() = %v", err)
+		}
+		return varlink.NewClient(conn)
+	}
+
+	idle := dial()
+	defer idle.Close()
+	if err := idle.Do("org.example.test.Ping", nil, nil); err != nil {
+		t.Fatalf("Do(Ping) = %v", err)
+	}
+
+	busy := dial()
+	defer busy.Close()
+	callDone := make(chan error, 1)
+	go func() {
+		callDone <- busy.Do("org.example.test.Wait", nil, nil)
+	}()
+	<-started
+
+	shutdownDone := make(chan error, 1)
+	go func() {
+		shutdownDone <- srv.Shutdown(context.Background())
+	}()
+
+	select {
+	case err := <-serveDone:
+		if err != varlink.ErrServerClosed {
+			t.Errorf("Serve() = %v, want %v", err, varlink.ErrServerClosed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Serve() did not return after Shutdown")
+	}
+
+	// The idle connection is closed right away
+	if err := idle.Do("org.example.test.Ping", nil, nil); err == nil {
+		t.Errorf("Do(Ping) on idle connection after Shutdown = nil, want an error")
+	}
+
+	select {
+	case err := <-shutdownDone:
+		t.Fatalf("Shutdown() = %v before the call in progress completed", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	close(release)
+	if err := <-callDone; err != nil {
+		t.Errorf("Do(Wait) = %v", err)
+	}
+	select {
+	case err := <-shutdownDone:
+		if err != nil {
+			t.Errorf("Shutdown() = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Shutdown() did not return after the call completed")
+	}
+
+	if err := srv.Serve(ln); err != varlink.ErrServerClosed {
+		t.Errorf("Serve() after Shutdown = %v, want %v", err, varlink.ErrServerClosed)
+	}
+}
+
+func TestServer_Shutdown_timeout(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen() = %v", err)
+	}
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	srv := varlink.NewServer()
+	srv.Handler = handlerFunc(func(call *varlink.ServerCall, req *varlink.ServerRequest) error {
+		close(started)
+		<-release
+		return call.CloseWithReply(nil)
+	})
+	go srv.Serve(ln)
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("net.Dial() = %v", err)
+	}
+	c := varlink.NewClient(conn)
+	defer c.Close()
+	go c.Do("org.example.test.Wait", nil, nil)
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Shutdown() = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestServer_MaxConns(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen() = %v", err)
+	}
+	defer ln.Close()
+
+	srv := &varlink.Server{MaxConns: 2}
+	srv.Handler = handlerFunc(func(call *varlink.ServerCall, req *varlink.ServerRequest) error {
+		return call.CloseWithReply(nil)
+	})
+	go srv.Serve(ln)
+
+	dial := func() *varlink.Client {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatalf("net.Dial() = %v", err)
+		}
+		c := varlink.NewClient(conn)
+		t.Cleanup(func() { c.Close() })
+		return c
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := dial().Do("org.example.test.Ping", nil, nil); err != nil {
+			t.Fatalf("Do() = %v", err)
+		}
+	}
+	if n := srv.ActiveConns(); n != 2 {
+		t.Errorf("ActiveConns() = %v, want 2", n)
+	}
+
+	// The third connection exceeds the limit and is closed
+	if err := dial().Do("org.example.test.Ping", nil, nil); err == nil {
+		t.Errorf("Do() over the connection limit = nil, want an error")
+	}
+	if n := srv.ActiveConns(); n != 2 {
+		t.Errorf("ActiveConns() = %v, want 2", n)
+	}
+}
+
+func TestServer_PanicHandler(t *testing.T) {
+	panics := make(chan interface{}, 1)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen() = %v", err)
+	}
+	defer ln.Close()
+
+	srv := &varlink.Server{PanicHandler: func(v interface{}) { panics <- v }}
+	srv.Handler = handlerFunc(func(call *varlink.ServerCall, req *varlink.ServerRequest) error {
+		if req.Method == "org.example.test.Panic" {
+			panic("oops")
+		}
+		return call.CloseWithReply(nil)
+	})
+	go srv.Serve(ln)
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("net.Dial() = %v", err)
+	}
+	c := varlink.NewClient(conn)
+	defer c.Close()
+
+	err = c.Do("org.example.test.Panic", nil, nil)
+	verr, ok := err.(*varlink.ClientError)
+	if !ok || verr.Name != varlink.DefaultInternalErrorName {
+		t.Fatalf("Do(Panic) = %v, want %v", err, varlink.DefaultInternalErrorName)
+	}
+	if v := <-panics; v != "oops" {
+		t.Errorf("PanicHandler called with %v, want %q", v, "oops")
+	}
+
+	// The connection is still usable
+	if err := c.Do("org.example.test.Ping", nil, nil); err != nil {
+		t.Errorf("Do(Ping) after panic = %v", err)
+	}
+}
+
+func TestServerCall_Upgrade(t *testing.T) {
+	conn := newTestConn(t, handlerFunc(func(call *varlink.ServerCall, req *varlink.ServerRequest) error {
+		if !req.Upgrade {
+			if _, _, err := call.Upgrade(nil); err == nil {
+				t.Errorf("Upgrade() without Upgrade set = nil, want an error")
+			}
+			return call.CloseWithReply(nil)
+		}
+
+		conn, br, err := call.Upgrade(map[string]string{"protocol": "echo"})
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			line, err := br.ReadString('\n')
+			if err != nil {
+				t.Errorf("ReadString() = %v", err)
+				return
+			}
+			conn.Write([]byte("echo: " + line))
+		}()
+		return nil
+	}))
+	defer conn.Close()
+
+	// A regular call before the upgrade
+	br := bufio.NewReader(conn)
+	if _, err := conn.Write([]byte(`{"method":"org.example.test.Ping"}` + "\x00")); err != nil {
+		t.Fatalf("Write() = %v", err)
+	}
+	if _, err := br.ReadBytes(0); err != nil {
+		t.Fatalf("ReadBytes() = %v", err)
+	}
+
+	// Raw data sent right after the request ends up buffered by the server
+	if _, err := conn.Write([]byte(`{"method":"org.example.test.Echo","upgrade":true}` + "\x00" + "hello\n")); err != nil {
+		t.Fatalf("Write() = %v", err)
+	}
+	b, err := br.ReadBytes(0)
+	if want := `{"parameters":{"protocol":"echo"}}` + "\x00"; err != nil || string(b) != want {
+		t.Fatalf("ReadBytes() = %q, %v, want %q", b, err, want)
+	}
+	line, err := br.ReadString('\n')
+	if err != nil || line != "echo: hello\n" {
+		t.Errorf("ReadString() = %q, %v, want %q", line, err, "echo: hello\n")
+	}
+}
+
+func TestServerCall_PeerCredentials_tcp(t *testing.T) {
+	c := newTestClient(t, handlerFunc(func(call *varlink.ServerCall, req *varlink.ServerRequest) error {
+		if _, err := call.PeerCredentials(); err != varlink.ErrNoPeerCredentials {
+			t.Errorf("PeerCredentials() = %v, want ErrNoPeerCredentials", err)
+		}
+		if _, ok := varlink.PeerFromContext(call.Context()); ok {
+			t.Errorf("PeerFromContext() = true, want false")
+		}
+		return call.CloseWithReply(nil)
+	}))
+	if err := c.Do("org.example.test.WhoAmI", nil, nil); err != nil {
+		t.Fatalf("Do() = %v", err)
+	}
+}
@@ -0,0 +1,83 @@
+package varlink
+
+import (
+	"net"
+	"os"
+	"time"
+)
+
+// ServeStdio serves a single Varlink connection over the standard input and
+// output.
+//
+// This is useful for services started via an "exec:" address. nil is
+// returned when the standard input reaches EOF. The standard input and output
+// are left open, so that they can still be used once ServeStdio returns.
+func ServeStdio(h Handler) error {
+	srv := &Server{Handler: h}
+	return srv.serveConn(newConn(&processStdioConn{stdioConn{r: os.Stdin, w: os.Stdout}}, nil))
+}
+
+type stdioAddr struct{}
+
+func (stdioAddr) Network() string {
+	return "stdio"
+}
+
+func (stdioAddr) String() string {
+	return "stdio"
+}
+
+// stdioConn is a net.Conn backed by a pair of files.
+type stdioConn struct {
+	r, w *os.File
+}
+
+func (c *stdioConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+func (c *stdioConn) Write(b []byte) (int, error) {
+	return c.w.Write(b)
+}
+
+func (c *stdioConn) Close() error {
+	rerr := c.r.Close()
+	werr := c.w.Close()
+	if rerr != nil {
+		return rerr
+	}
+	return werr
+}
+
+func (c *stdioConn) LocalAddr() net.Addr {
+	return stdioAddr{}
+}
+
+func (c *stdioConn) RemoteAddr() net.Addr {
+	return stdioAddr{}
+}
+
+func (c *stdioConn) SetDeadline(t time.Time) error {
+	if err := c.r.SetDeadline(t); err != nil {
+		return err
+	}
+	return c.w.SetDeadline(t)
+}
+
+func (c *stdioConn) SetReadDeadline(t time.Time) error {
+	return c.r.SetReadDeadline(t)
+}
+
+func (c *stdioConn) SetWriteDeadline(t time.Time) error {
+	return c.w.SetWriteDeadline(t)
+}
+
+// processStdioConn is a stdioConn over the standard input and output of the
+// process, which are not closed with the connection.
+type processStdioConn struct {
+	stdioConn
+}
+
+func (c *processStdioConn) Close() error {
+	return nil
+}
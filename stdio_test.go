@@ -0,0 +1,96 @@
+package varlink_test
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-varlink"
+)
+
+func TestServeStdio(t *testing.T) {
+	stdinR, stdinW, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("os.Pipe() = %v", err)
+	}
+	stdoutR, stdoutW, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("os.Pipe() = %v", err)
+	}
+	defer stdoutR.Close()
+	defer stdinR.Close()
+	defer stdoutW.Close()
+
+	oldStdin, oldStdout := os.Stdin, os.Stdout
+	os.Stdin, os.Stdout = stdinR, stdoutW
+	defer func() {
+		os.Stdin, os.Stdout = oldStdin, oldStdout
+	}()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- varlink.ServeStdio(handlerFunc(func(call *varlink.ServerCall, req *varlink.ServerRequest) error {
+			return call.CloseWithReply(map[string]string{"method": req.Method})
+		}))
+	}()
+
+	if _, err := stdinW.Write([]byte(`{"method":"org.example.test.Ping"}` + "\x00")); err != nil {
+		t.Fatalf("Write() = %v", err)
+	}
+
+	want := `{"parameters":{"method":"org.example.test.Ping"}}` + "\x00"
+	buf := make([]byte, len(want))
+	if _, err := io.ReadFull(stdoutR, buf); err != nil {
+		t.Fatalf("ReadFull() = %v", err)
+	} else if string(buf) != want {
+		t.Errorf("got reply %q, want %q", buf, want)
+	}
+
+	stdinW.Close()
+	if err := <-done; err != nil {
+		t.Errorf("ServeStdio() = %v", err)
+	}
+
+	// The standard output is left open
+	if _, err := stdoutW.Write([]byte("bye")); err != nil {
+		t.Errorf("Write() to stdout after ServeStdio() = %v", err)
+	}
+}
+
+func TestServeStdio_closeWithReplyNotCalled(t *testing.T) {
+	stdinR, stdinW, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("os.Pipe() = %v", err)
+	}
+	defer stdinR.Close()
+	defer stdinW.Close()
+	stdoutR, stdoutW, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("os.Pipe() = %v", err)
+	}
+	defer stdoutR.Close()
+	defer stdoutW.Close()
+
+	oldStdin, oldStdout := os.Stdin, os.Stdout
+	os.Stdin, os.Stdout = stdinR, stdoutW
+	defer func() {
+		os.Stdin, os.Stdout = oldStdin, oldStdout
+	}()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- varlink.ServeStdio(handlerFunc(func(call *varlink.ServerCall, req *varlink.ServerRequest) error {
+			return nil
+		}))
+	}()
+
+	if _, err := stdinW.Write([]byte(`{"method":"org.example.test.Forgetful"}` + "\x00")); err != nil {
+		t.Fatalf("Write() = %v", err)
+	}
+
+	err = <-done
+	if err == nil || !strings.Contains(err.Error(), `"org.example.test.Forgetful"`) {
+		t.Errorf("ServeStdio() = %v, want an error mentioning the method", err)
+	}
+}
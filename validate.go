@@ -0,0 +1,135 @@
+package varlink
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/emersion/go-varlink/varlinkdef"
+)
+
+// validateParameters checks that v marshals to a JSON object matching the
+// input parameters of a method defined in iface.
+func validateParameters(iface *varlinkdef.Interface, method string, v interface{}) error {
+	name := strings.TrimPrefix(method, iface.Name+".")
+	m, ok := iface.Methods[name]
+	if !ok || name == method {
+		return fmt.Errorf("method %q not found in interface %q", method, iface.Name)
+	}
+
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return validateStruct(iface, m.In, raw, "")
+}
+
+func validateStruct(iface *varlinkdef.Interface, st varlinkdef.Struct, raw json.RawMessage, path string) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil || fields == nil {
+		return fmt.Errorf("%vexpected an object", fieldPrefix(path))
+	}
+
+	for k := range fields {
+		if _, ok := st[k]; !ok {
+			return fmt.Errorf("unknown field %q", joinPath(path, k))
+		}
+	}
+	for k, t := range st {
+		t := t
+		v, ok := fields[k]
+		if !ok {
+			if t.Nullable {
+				continue
+			}
+			return fmt.Errorf("missing field %q", joinPath(path, k))
+		}
+		if err := validateValue(iface, &t, v, joinPath(path, k)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateValue(iface *varlinkdef.Interface, typ *varlinkdef.Type, raw json.RawMessage, path string) error {
+	if bytes.Equal(bytes.TrimSpace(raw), []byte("null")) {
+		if typ.Nullable {
+			return nil
+		}
+		return fmt.Errorf("%vunexpected null", fieldPrefix(path))
+	}
+
+	var err error
+	switch typ.Kind {
+	case varlinkdef.KindStruct:
+		return validateStruct(iface, typ.Struct, raw, path)
+	case varlinkdef.KindEnum:
+		var s string
+		if err = json.Unmarshal(raw, &s); err == nil {
+			for _, v := range typ.Enum {
+				if s == v {
+					return nil
+				}
+			}
+			return fmt.Errorf("%vinvalid enum value %q", fieldPrefix(path), s)
+		}
+	case varlinkdef.KindName:
+		named, ok := iface.Types[typ.Name]
+		if !ok {
+			return fmt.Errorf("%vundefined type %q", fieldPrefix(path), typ.Name)
+		}
+		return validateValue(iface, &named, raw, path)
+	case varlinkdef.KindBool:
+		var b bool
+		err = json.Unmarshal(raw, &b)
+	case varlinkdef.KindInt:
+		var i int64
+		err = json.Unmarshal(raw, &i)
+	case varlinkdef.KindFloat:
+		var f float64
+		err = json.Unmarshal(raw, &f)
+	case varlinkdef.KindString:
+		var s string
+		err = json.Unmarshal(raw, &s)
+	case varlinkdef.KindObject:
+		var m map[string]json.RawMessage
+		err = json.Unmarshal(raw, &m)
+	case varlinkdef.KindArray:
+		var l []json.RawMessage
+		if err = json.Unmarshal(raw, &l); err == nil {
+			for _, v := range l {
+				if err := validateValue(iface, typ.Inner, v, path+"[]"); err != nil {
+					return err
+				}
+			}
+		}
+	case varlinkdef.KindMap:
+		var m map[string]json.RawMessage
+		if err = json.Unmarshal(raw, &m); err == nil {
+			for _, v := range m {
+				if err := validateValue(iface, typ.Inner, v, path+"[]"); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("%vexpected %v", fieldPrefix(path), typ.Kind)
+	}
+	return nil
+}
+
+func joinPath(path, k string) string {
+	if path == "" {
+		return k
+	}
+	return path + "." + k
+}
+
+func fieldPrefix(path string) string {
+	if path == "" {
+		return ""
+	}
+	return fmt.Sprintf("field %q: ", path)
+}
@@ -6,19 +6,54 @@ package varlink
 import (
 	"bufio"
 	"encoding/json"
+	"fmt"
+	"io"
 	"net"
+	"time"
 )
 
+// ConnOptions contains connection options shared by clients and servers.
+type ConnOptions struct {
+	// Size of the read buffer. If zero, a default size is used.
+	ReadBufferSize int
+	// Maximum size of a single incoming message in bytes, excluding the NUL
+	// terminator. If a larger message is received, the connection is
+	// closed. If zero, messages are not limited.
+	MaxMessageSize int
+	// Maximum duration to write a single message. If zero, writes don't
+	// time out.
+	WriteTimeout time.Duration
+}
+
 type conn struct {
 	net.Conn
 
-	br *bufio.Reader
+	br             *bufio.Reader
+	maxMessageSize int
+	writeTimeout   time.Duration
+
+	// Total number of bytes of messages read, including NUL terminators
+	bytesRead int64
+
+	// If non-nil, receives a copy of each message read and written
+	tap io.Writer
 }
 
-func newConn(c net.Conn) *conn {
+func newConn(c net.Conn, options *ConnOptions) *conn {
+	if options == nil {
+		options = &ConnOptions{}
+	}
+	var br *bufio.Reader
+	if options.ReadBufferSize > 0 {
+		br = bufio.NewReaderSize(c, options.ReadBufferSize)
+	} else {
+		br = bufio.NewReader(c)
+	}
 	return &conn{
-		Conn: c,
-		br:   bufio.NewReader(c),
+		Conn:           c,
+		br:             br,
+		maxMessageSize: options.MaxMessageSize,
+		writeTimeout:   options.WriteTimeout,
 	}
 }
 
@@ -27,16 +62,81 @@ func (c *conn) writeMessage(v interface{}) error {
 	if err != nil {
 		return err
 	}
+	c.tapMessage("-> ", b)
 	b = append(b, 0)
+	if c.writeTimeout > 0 {
+		if err := c.SetWriteDeadline(time.Now().Add(c.writeTimeout)); err != nil {
+			return err
+		}
+	}
 	_, err = c.Write(b)
 	return err
 }
 
 func (c *conn) readMessage(v interface{}) error {
-	b, err := c.br.ReadBytes(0)
+	b, err := c.readFrame()
 	if err != nil {
 		return err
 	}
-	b = b[:len(b)-1]
-	return json.Unmarshal(b, v)
+	c.tapMessage("<- ", b)
+	if err := json.Unmarshal(b, v); err != nil {
+		return &malformedMessageError{err}
+	}
+	return nil
+}
+
+// malformedMessageError is returned by conn.readMessage when a complete
+// message was read but couldn't be decoded. The connection is positioned at
+// the start of the next message.
+type malformedMessageError struct {
+	err error
+}
+
+func (err *malformedMessageError) Error() string {
+	return err.err.Error()
+}
+
+func (err *malformedMessageError) Unwrap() error {
+	return err.err
+}
+
+// readFrame reads a NUL-terminated message, without the terminator.
+func (c *conn) readFrame() ([]byte, error) {
+	if c.maxMessageSize <= 0 {
+		b, err := c.br.ReadBytes(0)
+		c.bytesRead += int64(len(b))
+		if err != nil {
+			return nil, err
+		}
+		return b[:len(b)-1], nil
+	}
+
+	var b []byte
+	for {
+		chunk, err := c.br.ReadSlice(0)
+		if len(b)+len(chunk) > c.maxMessageSize+1 {
+			return nil, fmt.Errorf("varlink: message exceeds %v bytes", c.maxMessageSize)
+		}
+		b = append(b, chunk...)
+		c.bytesRead += int64(len(chunk))
+		if err == bufio.ErrBufferFull {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		return b[:len(b)-1], nil
+	}
+}
+
+// tapMessage writes a message to the tap, prefixed with a direction marker
+// and terminated by a newline. Errors are ignored.
+func (c *conn) tapMessage(dir string, msg []byte) {
+	if c.tap == nil {
+		return
+	}
+	b := make([]byte, 0, len(dir)+len(msg)+1)
+	b = append(b, dir...)
+	b = append(b, msg...)
+	b = append(b, '\n')
+	c.tap.Write(b)
 }
@@ -0,0 +1,78 @@
+package varlinkdef
+
+import (
+	"strings"
+)
+
+// Diff returns a human-readable summary of the differences between two
+// interface definitions, or an empty string if they are identical.
+//
+// Each removed member is written on a line starting with "- ", each added
+// member on a line starting with "+ ", and each changed member as a removal
+// followed by an addition. Members are grouped by kind (types, methods, then
+// errors) and sorted by name, as written by Write. Documentation comments are
+// ignored.
+func Diff(old, new *Interface) string {
+	var sb strings.Builder
+	if old.Name != new.Name {
+		sb.WriteString("- interface " + old.Name + "\n")
+		sb.WriteString("+ interface " + new.Name + "\n")
+	}
+
+	diffMembers(&sb, old.Types, new.Types, func(name string, typ Type) string {
+		return "type " + name + " " + typeString(&typ)
+	})
+	diffMembers(&sb, old.Methods, new.Methods, func(name string, method Method) string {
+		return "method " + name + structString(method.In) + " -> " + structString(method.Out)
+	})
+	diffMembers(&sb, old.Errors, new.Errors, func(name string, st Struct) string {
+		return "error " + name + " " + structString(st)
+	})
+
+	return sb.String()
+}
+
+func diffMembers[V any](sb *strings.Builder, old, new map[string]V, format func(name string, v V) string) {
+	names := make(map[string]struct{})
+	for name := range old {
+		names[name] = struct{}{}
+	}
+	for name := range new {
+		names[name] = struct{}{}
+	}
+
+	for _, name := range sortedKeys(names) {
+		var oldDef, newDef string
+		if v, ok := old[name]; ok {
+			oldDef = format(name, v)
+		}
+		if v, ok := new[name]; ok {
+			newDef = format(name, v)
+		}
+		if oldDef == newDef {
+			continue
+		}
+		if oldDef != "" {
+			sb.WriteString("- " + oldDef + "\n")
+		}
+		if newDef != "" {
+			sb.WriteString("+ " + newDef + "\n")
+		}
+	}
+}
+
+func typeString(t *Type) string {
+	var sb strings.Builder
+	if err := writeType(&sb, t); err != nil {
+		return "<invalid type: " + err.Error() + ">"
+	}
+	return sb.String()
+}
+
+func structString(st Struct) string {
+	var sb strings.Builder
+	if err := writeStruct(&sb, st); err != nil {
+		return "<invalid struct: " + err.Error() + ">"
+	}
+	return sb.String()
+}
@@ -0,0 +1,50 @@
+package varlinkdef_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-varlink/varlinkdef"
+)
+
+func TestDiff(t *testing.T) {
+	old, err := varlinkdef.ReadString(serviceRaw)
+	if err != nil {
+		t.Fatalf("ReadString() = %v", err)
+	}
+
+	if diff := varlinkdef.Diff(old, old); diff != "" {
+		t.Errorf("Diff(iface, iface) = %q, want an empty string", diff)
+	}
+
+	// Documentation changes are ignored
+	raw := strings.Replace(serviceRaw, "# The requested method was not found\n", "", 1)
+	// Changed method
+	raw = strings.Replace(raw, "  url: string,\n", "  url: ?string,\n", 1)
+	// Removed error
+	raw = strings.Replace(raw, "error InvalidParameter (parameter: string)\n", "", 1)
+	// Added type and method
+	raw += "type Status (up, down)\nmethod GetStatus() -> (status: Status)\n"
+
+	new, err := varlinkdef.ReadString(raw)
+	if err != nil {
+		t.Fatalf("ReadString() = %v", err)
+	}
+
+	want := `+ type Status (up, down)
+- method GetInfo() -> (interfaces: []string, product: string, url: string, vendor: string, version: string)
++ method GetInfo() -> (interfaces: []string, product: string, url: ?string, vendor: string, version: string)
++ method GetStatus() -> (status: Status)
+- error InvalidParameter (parameter: string)
+`
+	if diff := varlinkdef.Diff(old, new); diff != want {
+		t.Errorf("Diff() = \n%v\nwant:\n%v", diff, want)
+	}
+
+	renamed := *old
+	renamed.Name = "org.example.service"
+	want = "- interface org.varlink.service\n+ interface org.example.service\n"
+	if diff := varlinkdef.Diff(old, &renamed); diff != want {
+		t.Errorf("Diff() = %q, want %q", diff, want)
+	}
+}
@@ -0,0 +1,218 @@
+package varlinkdef
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// GoInterface describes a Varlink interface with Go types, see FromGo.
+type GoInterface struct {
+	Name string
+	// Methods maps method names to their input and output parameters.
+	Methods map[string]GoMethod
+	// Errors maps error names to their parameters. Values must be structs or
+	// pointers to structs.
+	Errors map[string]interface{}
+}
+
+// GoMethod describes the parameters of a method with Go types. In and Out
+// must be structs or pointers to structs, e.g. (*JumpIn)(nil).
+type GoMethod struct {
+	In, Out interface{}
+}
+
+// FromGo builds an interface definition from Go types. This is the inverse of
+// varlinkgen: the result can be written to a .varlink file with Write.
+//
+// Go types are mapped to Varlink types as follows:
+//
+//   - bool, integers, floats and strings to the corresponding basic type
+//   - json.RawMessage to object
+//   - pointers to nullable types
+//   - slices and arrays to arrays
+//   - maps with string keys to maps
+//   - named structs to named types, anonymous structs to inline structs
+//
+// Named string types are mapped to string, since their values are unknown.
+// Struct fields are named after their json tag, or after the Go field name if
+// the tag has none. Unexported fields and fields tagged "-" are skipped.
+// Other types (e.g. byte slices, channels, functions, interfaces, pointers to
+// pointers and embedded fields) are rejected with an error.
+func FromGo(def *GoInterface) (*Interface, error) {
+	if !isInterfaceName(def.Name) {
+		return nil, fmt.Errorf("varlinkdef: invalid interface name %q", def.Name)
+	}
+
+	conv := goConverter{
+		iface: &Interface{
+			Name:    def.Name,
+			Types:   make(map[string]Type),
+			Methods: make(map[string]Method),
+			Errors:  make(map[string]Struct),
+		},
+		named: make(map[string]reflect.Type),
+	}
+
+	for _, name := range sortedKeys(def.Methods) {
+		if !isName(name) {
+			return nil, fmt.Errorf("varlinkdef: invalid method name %q", name)
+		}
+		m := def.Methods[name]
+		in, err := conv.params(m.In)
+		if err != nil {
+			return nil, fmt.Errorf("varlinkdef: in method %q input: %v", name, err)
+		}
+		out, err := conv.params(m.Out)
+		if err != nil {
+			return nil, fmt.Errorf("varlinkdef: in method %q output: %v", name, err)
+		}
+		conv.iface.Methods[name] = Method{In: in, Out: out}
+	}
+
+	for _, name := range sortedKeys(def.Errors) {
+		if !isName(name) {
+			return nil, fmt.Errorf("varlinkdef: invalid error name %q", name)
+		}
+		params, err := conv.params(def.Errors[name])
+		if err != nil {
+			return nil, fmt.Errorf("varlinkdef: in error %q: %v", name, err)
+		}
+		conv.iface.Errors[name] = params
+	}
+
+	return conv.iface, nil
+}
+
+type goConverter struct {
+	iface *Interface
+	// Go types of the named types added to iface.Types
+	named map[string]reflect.Type
+}
+
+func (conv *goConverter) params(v interface{}) (Struct, error) {
+	t := reflect.TypeOf(v)
+	if t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("expected a struct, got %v", t)
+	}
+	return conv.structFields(t)
+}
+
+func (conv *goConverter) structFields(t reflect.Type) (Struct, error) {
+	st := make(Struct)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous {
+			return nil, fmt.Errorf("embedded field %v is not supported", f.Name)
+		} else if !f.IsExported() {
+			continue
+		}
+
+		name := f.Name
+		if tag, ok := f.Tag.Lookup("json"); ok {
+			tagName, _, _ := strings.Cut(tag, ",")
+			if tagName == "-" {
+				continue
+			} else if tagName != "" {
+				name = tagName
+			}
+		}
+		if !isFieldName(name) {
+			return nil, fmt.Errorf("invalid field name %q", name)
+		}
+
+		typ, err := conv.typeOf(f.Type)
+		if err != nil {
+			return nil, fmt.Errorf("in field %q: %v", name, err)
+		}
+		st[name] = *typ
+	}
+	return st, nil
+}
+
+var rawMessageType = reflect.TypeOf(json.RawMessage(nil))
+
+func (conv *goConverter) typeOf(t reflect.Type) (*Type, error) {
+	if t == rawMessageType {
+		return &Type{Kind: KindObject}, nil
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		if t.Elem().Kind() == reflect.Ptr {
+			return nil, fmt.Errorf("pointer to pointer %v is not supported", t)
+		}
+		inner, err := conv.typeOf(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		inner.Nullable = true
+		return inner, nil
+	case reflect.Bool:
+		return &Type{Kind: KindBool}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Type{Kind: KindInt}, nil
+	case reflect.Float32, reflect.Float64:
+		return &Type{Kind: KindFloat}, nil
+	case reflect.String:
+		return &Type{Kind: KindString}, nil
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			// encoding/json encodes these as base64 strings
+			return nil, fmt.Errorf("byte slice %v is not supported", t)
+		}
+		inner, err := conv.typeOf(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return &Type{Kind: KindArray, Inner: inner}, nil
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("map %v doesn't have string keys", t)
+		}
+		inner, err := conv.typeOf(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return &Type{Kind: KindMap, Inner: inner}, nil
+	case reflect.Struct:
+		if t.Name() == "" {
+			st, err := conv.structFields(t)
+			if err != nil {
+				return nil, err
+			}
+			return &Type{Kind: KindStruct, Struct: st}, nil
+		}
+		return conv.namedType(t)
+	default:
+		return nil, fmt.Errorf("unsupported type %v", t)
+	}
+}
+
+func (conv *goConverter) namedType(t reflect.Type) (*Type, error) {
+	name := t.Name()
+	if !isName(name) {
+		return nil, fmt.Errorf("invalid type name %q", name)
+	}
+
+	if prev, ok := conv.named[name]; ok {
+		if prev != t {
+			return nil, fmt.Errorf("type name %q is used by both %v and %v", name, prev, t)
+		}
+		return &Type{Kind: KindName, Name: name}, nil
+	}
+	// Register the type before converting its fields, for recursive types
+	conv.named[name] = t
+
+	st, err := conv.structFields(t)
+	if err != nil {
+		return nil, fmt.Errorf("in type %q: %v", name, err)
+	}
+	conv.iface.Types[name] = Type{Kind: KindStruct, Struct: st}
+	return &Type{Kind: KindName, Name: name}, nil
+}
@@ -0,0 +1,102 @@
+package varlinkdef_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-varlink/varlinkdef"
+)
+
+// Coordinate is named after the Varlink type it maps to.
+type Coordinate struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Distance  int64   `json:"distance"`
+}
+
+type fromGoJumpIn struct {
+	Target    Coordinate        `json:"target"`
+	Waypoints []Coordinate      `json:"waypoints"`
+	Tags      map[string]string `json:"tags"`
+	Note      *string           `json:"note,omitempty"`
+	Extra     json.RawMessage   `json:"extra"`
+	Options   struct {
+		Fast bool `json:"fast"`
+	} `json:"options"`
+	Skipped  string `json:"-"`
+	internal int
+}
+
+type fromGoJumpOut struct{}
+
+type fromGoNotFoundError struct {
+	Name string
+}
+
+func TestFromGo(t *testing.T) {
+	iface, err := varlinkdef.FromGo(&varlinkdef.GoInterface{
+		Name: "org.example.fromgo",
+		Methods: map[string]varlinkdef.GoMethod{
+			"Jump": {In: (*fromGoJumpIn)(nil), Out: fromGoJumpOut{}},
+		},
+		Errors: map[string]interface{}{
+			"NotFound": fromGoNotFoundError{},
+		},
+	})
+	if err != nil {
+		t.Fatalf("FromGo() = %v", err)
+	}
+
+	var sb strings.Builder
+	if err := varlinkdef.Write(&sb, iface); err != nil {
+		t.Fatalf("Write() = %v", err)
+	}
+
+	const want = `interface org.example.fromgo
+
+type Coordinate (distance: int, latitude: float, longitude: float)
+
+method Jump(extra: object, note: ?string, options: (fast: bool), tags: [string]string, target: Coordinate, waypoints: []Coordinate) -> ()
+
+error NotFound (Name: string)
+`
+	if got := sb.String(); got != want {
+		t.Errorf("Write() = \n%v\nwant:\n%v", got, want)
+	}
+
+	if _, err := varlinkdef.ReadString(sb.String()); err != nil {
+		t.Errorf("ReadString() = %v", err)
+	}
+}
+
+func TestFromGo_unsupported(t *testing.T) {
+	tests := []struct {
+		name string
+		in   interface{}
+	}{
+		{"notStruct", "string"},
+		{"nil", nil},
+		{"byteSlice", struct{ B []byte }{}},
+		{"channel", struct{ C chan int }{}},
+		{"interface", struct{ I interface{} }{}},
+		{"intKeys", struct{ M map[int]string }{}},
+		{"doublePointer", struct{ P **string }{}},
+		{"embedded", struct{ Coordinate }{}},
+		{"invalidFieldName", struct {
+			F string `json:"my-field"`
+		}{}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := varlinkdef.FromGo(&varlinkdef.GoInterface{
+				Name:    "org.example.fromgo",
+				Methods: map[string]varlinkdef.GoMethod{"Get": {In: tc.in, Out: struct{}{}}},
+			})
+			if err == nil {
+				t.Errorf("FromGo() = nil, want an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package varlinkdef
+
+import (
+	"fmt"
+)
+
+const jsonSchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// JSONSchema returns a JSON Schema document describing the interface.
+//
+// Types are defined under "$defs" and referenced via "$ref". The "methods"
+// object maps each method name to an object with "input" and "output"
+// schemas, and the "errors" object maps each error name to the schema of its
+// parameters.
+//
+// Varlink kinds are mapped as follows: structs to objects with properties
+// (non-nullable fields are required), enums to strings with an "enum"
+// keyword, arrays to arrays, maps to objects with "additionalProperties",
+// and nullable types to an "anyOf" with "null". An error is returned if a
+// named type is not defined in the interface.
+func (iface *Interface) JSONSchema() (map[string]interface{}, error) {
+	defs := make(map[string]interface{}, len(iface.Types))
+	for name, typ := range iface.Types {
+		typ := typ
+		schema, err := iface.typeSchema(&typ)
+		if err != nil {
+			return nil, fmt.Errorf("in type %q: %v", name, err)
+		}
+		defs[name] = schema
+	}
+
+	methods := make(map[string]interface{}, len(iface.Methods))
+	for name, method := range iface.Methods {
+		in, err := iface.structSchema(method.In)
+		if err != nil {
+			return nil, fmt.Errorf("in method %q input: %v", name, err)
+		}
+		out, err := iface.structSchema(method.Out)
+		if err != nil {
+			return nil, fmt.Errorf("in method %q output: %v", name, err)
+		}
+		methods[name] = map[string]interface{}{
+			"input":  in,
+			"output": out,
+		}
+	}
+
+	errors := make(map[string]interface{}, len(iface.Errors))
+	for name, st := range iface.Errors {
+		schema, err := iface.structSchema(st)
+		if err != nil {
+			return nil, fmt.Errorf("in error %q: %v", name, err)
+		}
+		errors[name] = schema
+	}
+
+	return map[string]interface{}{
+		"$schema": jsonSchemaDialect,
+		"title":   iface.Name,
+		"$defs":   defs,
+		"methods": methods,
+		"errors":  errors,
+	}, nil
+}
+
+func (iface *Interface) typeSchema(typ *Type) (map[string]interface{}, error) {
+	if typ.Nullable {
+		t := *typ
+		t.Nullable = false
+		schema, err := iface.typeSchema(&t)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{
+			"anyOf": []interface{}{schema, map[string]interface{}{"type": "null"}},
+		}, nil
+	}
+
+	if (typ.Kind == KindArray || typ.Kind == KindMap) && typ.Inner == nil {
+		return nil, fmt.Errorf("missing inner type for %v", typ.Kind)
+	}
+
+	switch typ.Kind {
+	case KindStruct:
+		return iface.structSchema(typ.Struct)
+	case KindEnum:
+		return map[string]interface{}{
+			"type": "string",
+			"enum": []string(typ.Enum),
+		}, nil
+	case KindName:
+		if _, ok := iface.Types[typ.Name]; !ok {
+			return nil, fmt.Errorf("undefined type %q", typ.Name)
+		}
+		return map[string]interface{}{"$ref": "#/$defs/" + typ.Name}, nil
+	case KindBool:
+		return map[string]interface{}{"type": "boolean"}, nil
+	case KindInt:
+		return map[string]interface{}{"type": "integer"}, nil
+	case KindFloat:
+		return map[string]interface{}{"type": "number"}, nil
+	case KindString:
+		return map[string]interface{}{"type": "string"}, nil
+	case KindObject:
+		return map[string]interface{}{"type": "object"}, nil
+	case KindArray:
+		items, err := iface.typeSchema(typ.Inner)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{
+			"type":  "array",
+			"items": items,
+		}, nil
+	case KindMap:
+		values, err := iface.typeSchema(typ.Inner)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": values,
+		}, nil
+	default:
+		return nil, fmt.Errorf("invalid kind %v", int(typ.Kind))
+	}
+}
+
+func (iface *Interface) structSchema(st Struct) (map[string]interface{}, error) {
+	props := make(map[string]interface{}, len(st))
+	var required []string
+	for _, name := range sortedKeys(st) {
+		t := st[name]
+		schema, err := iface.typeSchema(&t)
+		if err != nil {
+			return nil, fmt.Errorf("in field %q: %v", name, err)
+		}
+		props[name] = schema
+		if !t.Nullable {
+			required = append(required, name)
+		}
+	}
+
+	schema := map[string]interface{}{
+		"type":       "object",
+		"properties": props,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema, nil
+}
@@ -0,0 +1,181 @@
+package varlinkdef_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/emersion/go-varlink/varlinkdef"
+)
+
+const exampleSchema = `{
+	"$schema": "https://json-schema.org/draft/2020-12/schema",
+	"title": "org.example.ftl",
+	"$defs": {
+		"DriveCondition": {
+			"type": "object",
+			"properties": {
+				"state": {"type": "string", "enum": ["idle", "spooling", "busy"]},
+				"tylium_level": {"type": "integer"}
+			},
+			"required": ["state", "tylium_level"]
+		},
+		"DriveConfiguration": {
+			"type": "object",
+			"properties": {
+				"duration": {"type": "integer"},
+				"speed": {"type": "integer"},
+				"trajectory": {"type": "integer"}
+			},
+			"required": ["duration", "speed", "trajectory"]
+		},
+		"Coordinate": {
+			"type": "object",
+			"properties": {
+				"distance": {"type": "integer"},
+				"latitude": {"type": "number"},
+				"longitude": {"type": "number"}
+			},
+			"required": ["distance", "latitude", "longitude"]
+		}
+	},
+	"methods": {
+		"Monitor": {
+			"input": {"type": "object", "properties": {}},
+			"output": {
+				"type": "object",
+				"properties": {"condition": {"$ref": "#/$defs/DriveCondition"}},
+				"required": ["condition"]
+			}
+		},
+		"CalculateConfiguration": {
+			"input": {
+				"type": "object",
+				"properties": {
+					"current": {"$ref": "#/$defs/Coordinate"},
+					"target": {"$ref": "#/$defs/Coordinate"}
+				},
+				"required": ["current", "target"]
+			},
+			"output": {
+				"type": "object",
+				"properties": {"configuration": {"$ref": "#/$defs/DriveConfiguration"}},
+				"required": ["configuration"]
+			}
+		},
+		"Jump": {
+			"input": {
+				"type": "object",
+				"properties": {"configuration": {"$ref": "#/$defs/DriveConfiguration"}},
+				"required": ["configuration"]
+			},
+			"output": {"type": "object", "properties": {}}
+		}
+	},
+	"errors": {
+		"NotEnoughEnergy": {"type": "object", "properties": {}},
+		"ParameterOutOfRange": {
+			"type": "object",
+			"properties": {"field": {"type": "string"}},
+			"required": ["field"]
+		}
+	}
+}`
+
+const collectionsRaw = `interface org.example.collections
+
+method Get() -> (
+  tags: [string]string,
+  items: []?object,
+  flag: ?bool
+)
+`
+
+const collectionsSchema = `{
+	"$schema": "https://json-schema.org/draft/2020-12/schema",
+	"title": "org.example.collections",
+	"$defs": {},
+	"methods": {
+		"Get": {
+			"input": {"type": "object", "properties": {}},
+			"output": {
+				"type": "object",
+				"properties": {
+					"tags": {"type": "object", "additionalProperties": {"type": "string"}},
+					"items": {"type": "array", "items": {"anyOf": [{"type": "object"}, {"type": "null"}]}},
+					"flag": {"anyOf": [{"type": "boolean"}, {"type": "null"}]}
+				},
+				"required": ["items", "tags"]
+			}
+		}
+	},
+	"errors": {}
+}`
+
+func TestInterface_JSONSchema(t *testing.T) {
+	collectionsIface, err := varlinkdef.ReadString(collectionsRaw)
+	if err != nil {
+		t.Fatalf("ReadString() = %v", err)
+	}
+
+	tests := []struct {
+		Name      string
+		Interface *varlinkdef.Interface
+		Schema    string
+	}{
+		{"org.example.ftl", exampleIface, exampleSchema},
+		{"org.example.collections", collectionsIface, collectionsSchema},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			schema, err := tc.Interface.JSONSchema()
+			if err != nil {
+				t.Fatalf("JSONSchema() = %v", err)
+			}
+
+			// Compare the JSON representations
+			b, err := json.Marshal(schema)
+			if err != nil {
+				t.Fatalf("json.Marshal() = %v", err)
+			}
+			var got, want interface{}
+			if err := json.Unmarshal(b, &got); err != nil {
+				t.Fatalf("json.Unmarshal() = %v", err)
+			}
+			if err := json.Unmarshal([]byte(tc.Schema), &want); err != nil {
+				t.Fatalf("json.Unmarshal() = %v", err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("JSONSchema() = \n%s\n but want \n%s", b, tc.Schema)
+			}
+		})
+	}
+}
+
+func TestInterface_JSONSchema_undefinedType(t *testing.T) {
+	iface, err := varlinkdef.ReadString(`interface org.example.broken
+
+method Get() -> (value: Missing)
+`)
+	if err != nil {
+		t.Fatalf("ReadString() = %v", err)
+	}
+	if _, err := iface.JSONSchema(); err == nil {
+		t.Errorf("JSONSchema() = nil, want an error")
+	}
+}
+
+func TestInterface_JSONSchema_missingInner(t *testing.T) {
+	iface := &varlinkdef.Interface{
+		Name: "org.example.broken",
+		Methods: map[string]varlinkdef.Method{
+			"Get": {In: varlinkdef.Struct{}, Out: varlinkdef.Struct{
+				"tags": {Kind: varlinkdef.KindMap},
+			}},
+		},
+	}
+	if _, err := iface.JSONSchema(); err == nil {
+		t.Errorf("JSONSchema() = nil, want an error")
+	}
+}
@@ -0,0 +1,92 @@
+package varlinkdef
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Warning is a lint warning for an interface definition.
+type Warning struct {
+	// Position of the warning, starting at 1
+	Line, Column int
+	Message      string
+}
+
+// String returns a "line:column: message" representation of the warning.
+func (w Warning) String() string {
+	return fmt.Sprintf("%v:%v: %v", w.Line, w.Column, w.Message)
+}
+
+// ReadWithWarnings is similar to Read, but also returns a list of lint
+// warnings for definitions which don't follow the usual conventions:
+// missing doc comments, members not sorted by name, inconsistent
+// indentation and trailing whitespace.
+//
+// Warnings don't cause parsing to fail. They are sorted by position.
+func ReadWithWarnings(r io.Reader) (*Interface, []Warning, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	warnings := lintLines(b)
+	dec := newDecoder(bytes.NewReader(b))
+	dec.warnings = &warnings
+	iface, err := dec.readInterface()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sort.SliceStable(warnings, func(i, j int) bool {
+		if warnings[i].Line != warnings[j].Line {
+			return warnings[i].Line < warnings[j].Line
+		}
+		return warnings[i].Column < warnings[j].Column
+	})
+	return iface, warnings, nil
+}
+
+// lintLines checks whitespace usage line by line.
+func lintLines(b []byte) []Warning {
+	var warnings []Warning
+	var indentChar byte
+	for i, line := range bytes.Split(b, []byte("\n")) {
+		line = bytes.TrimSuffix(line, []byte("\r"))
+		lineNum := i + 1
+
+		trimmed := bytes.TrimRight(line, " \t")
+		if len(trimmed) < len(line) {
+			warnings = append(warnings, Warning{
+				Line:    lineNum,
+				Column:  len(trimmed) + 1,
+				Message: "trailing whitespace",
+			})
+		}
+		if len(trimmed) == 0 {
+			continue
+		}
+
+		indent := trimmed[:len(trimmed)-len(bytes.TrimLeft(trimmed, " \t"))]
+		if len(indent) == 0 {
+			continue
+		}
+		if bytes.ContainsRune(indent, ' ') && bytes.ContainsRune(indent, '\t') {
+			warnings = append(warnings, Warning{
+				Line:    lineNum,
+				Column:  1,
+				Message: "indentation mixes tabs and spaces",
+			})
+		} else if indentChar == 0 {
+			indentChar = indent[0]
+		} else if indent[0] != indentChar {
+			warnings = append(warnings, Warning{
+				Line:    lineNum,
+				Column:  1,
+				Message: "inconsistent indentation",
+			})
+		}
+	}
+	return warnings
+}
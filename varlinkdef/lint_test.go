@@ -0,0 +1,67 @@
+package varlinkdef_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-varlink/varlinkdef"
+)
+
+func TestReadWithWarnings(t *testing.T) {
+	raw := strings.Join([]string{
+		"interface org.example.messy",
+		"",
+		"# Zulu doc.",
+		"method Zulu(",
+		"  a: int,",
+		"\tb: int",
+		") -> () ",
+		"",
+		"method Alpha() -> ()",
+		"",
+		"# Error doc.",
+		"error Failed (",
+		" \tfield: string",
+		")",
+		"",
+	}, "\n")
+
+	iface, warnings, err := varlinkdef.ReadWithWarnings(strings.NewReader(raw))
+	if err != nil {
+		t.Fatalf("ReadWithWarnings() = %v", err)
+	}
+	if len(iface.Methods) != 2 {
+		t.Errorf("got %v methods, want 2", len(iface.Methods))
+	}
+
+	want := []varlinkdef.Warning{
+		{Line: 1, Column: 1, Message: "missing doc comment for interface"},
+		{Line: 6, Column: 1, Message: "inconsistent indentation"},
+		{Line: 7, Column: 8, Message: "trailing whitespace"},
+		{Line: 9, Column: 1, Message: `missing doc comment for method "Alpha"`},
+		{Line: 9, Column: 1, Message: `method "Alpha" is not sorted, it should come before "Zulu"`},
+		{Line: 13, Column: 1, Message: "indentation mixes tabs and spaces"},
+	}
+	if !reflect.DeepEqual(warnings, want) {
+		var l []string
+		for _, w := range warnings {
+			l = append(l, w.String())
+		}
+		t.Errorf("ReadWithWarnings() warnings = \n%v\n but want \n%v", strings.Join(l, "\n"), want)
+	}
+}
+
+func TestReadWithWarnings_clean(t *testing.T) {
+	_, warnings, err := varlinkdef.ReadWithWarnings(strings.NewReader(serviceRaw))
+	if err != nil {
+		t.Fatalf("ReadWithWarnings() = %v", err)
+	}
+	// The service interface is documented and consistently indented, but
+	// its members are not sorted
+	for _, w := range warnings {
+		if !strings.Contains(w.Message, "not sorted") {
+			t.Errorf("unexpected warning: %v", w)
+		}
+	}
+}
@@ -9,29 +9,152 @@ import (
 
 // Read parses a Varlink interface definition.
 func Read(r io.Reader) (*Interface, error) {
-	dec := decoder{br: bufio.NewReader(r)}
+	dec := newDecoder(r)
 	return dec.readInterface()
 }
 
+// ReadString parses a Varlink interface definition from a string.
+func ReadString(s string) (*Interface, error) {
+	return Read(strings.NewReader(s))
+}
+
+// ReadName reads the interface name of a Varlink interface definition. Only
+// the beginning of the definition is read, up to the interface name: the
+// rest isn't parsed nor validated.
+func ReadName(r io.Reader) (string, error) {
+	dec := newDecoder(r)
+	if err := dec.expectToken("interface"); err != nil {
+		return "", err
+	}
+	return dec.readInterfaceName()
+}
+
+// ReadLimited is similar to Read, but returns an error if the definition is
+// larger than max bytes.
+//
+// This should be used when parsing definitions from untrusted sources, for
+// instance introspection replies.
+func ReadLimited(r io.Reader, max int64) (*Interface, error) {
+	lr := &limitedReader{r: r, n: max}
+	iface, err := Read(lr)
+	if lr.exceeded {
+		return nil, fmt.Errorf("interface definition exceeds %v bytes", max)
+	}
+	return iface, err
+}
+
+// limitedReader is similar to io.LimitedReader, but records whether the
+// limit has been exceeded.
+type limitedReader struct {
+	r        io.Reader
+	n        int64
+	exceeded bool
+}
+
+func (lr *limitedReader) Read(b []byte) (int, error) {
+	if lr.n <= 0 {
+		// Check whether there is more data past the limit
+		var buf [1]byte
+		if n, err := lr.r.Read(buf[:]); n > 0 {
+			lr.exceeded = true
+			return 0, fmt.Errorf("size limit exceeded")
+		} else if err != nil {
+			return 0, err
+		}
+		// Don't report (0, nil) forever: consider the definition complete
+		return 0, io.EOF
+	}
+	if int64(len(b)) > lr.n {
+		b = b[:lr.n]
+	}
+	n, err := lr.r.Read(b)
+	lr.n -= int64(n)
+	return n, err
+}
+
+func newDecoder(r io.Reader) *decoder {
+	return &decoder{br: bufio.NewReader(r), lineStart: true, line: 1}
+}
+
 type decoder struct {
 	br *bufio.Reader
+
+	// Comment block being read, reset by blank lines
+	comments []string
+	// Comment block immediately preceding the last token
+	doc string
+	// True if no token has been read since the last newline
+	lineStart bool
+
+	// Current position, and position of the last token
+	line, col           int
+	prevCol             int
+	lastByte            byte
+	tokenLine, tokenCol int
+
+	// If non-nil, lint warnings are collected
+	warnings *[]Warning
+	// Last member name for each keyword, to check ordering
+	lastMember map[string]string
+}
+
+func (dec *decoder) readByte() (byte, error) {
+	ch, err := dec.br.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	dec.lastByte = ch
+	dec.prevCol = dec.col
+	if ch == '\n' {
+		dec.line++
+		dec.col = 0
+	} else {
+		dec.col++
+	}
+	return ch, nil
+}
+
+func (dec *decoder) unreadByte() {
+	dec.br.UnreadByte()
+	if dec.lastByte == '\n' {
+		dec.line--
+	}
+	dec.col = dec.prevCol
+}
+
+func (dec *decoder) warn(line, col int, format string, v ...interface{}) {
+	if dec.warnings == nil {
+		return
+	}
+	*dec.warnings = append(*dec.warnings, Warning{
+		Line:    line,
+		Column:  col,
+		Message: fmt.Sprintf(format, v...),
+	})
 }
 
-func (dec *decoder) skipComment() error {
+func (dec *decoder) readComment() (string, error) {
+	var sb strings.Builder
 	for {
-		ch, err := dec.br.ReadByte()
-		if err != nil {
-			return err
+		ch, err := dec.readByte()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return "", err
 		}
 		if ch == '\n' {
-			return nil
+			break
 		}
+		sb.WriteByte(ch)
 	}
+
+	s := strings.TrimSuffix(sb.String(), "\r")
+	return strings.TrimPrefix(s, " "), nil
 }
 
 func (dec *decoder) skipWhitespace() error {
 	for {
-		ch, err := dec.br.ReadByte()
+		ch, err := dec.readByte()
 		if err == io.EOF {
 			return nil
 		} else if err != nil {
@@ -39,14 +162,28 @@ func (dec *decoder) skipWhitespace() error {
 		}
 
 		switch ch {
-		case ' ', '\t', '\r', '\n':
+		case ' ', '\t', '\r':
 			// skip
+		case '\n':
+			if dec.lineStart {
+				// Blank line, the comment block isn't attached to the next
+				// token
+				dec.comments = nil
+			}
+			dec.lineStart = true
 		case '#':
-			if err := dec.skipComment(); err != nil {
+			comment, err := dec.readComment()
+			if err != nil {
 				return err
 			}
+			// Comments trailing a token on the same line are not part of
+			// a comment block
+			if dec.lineStart {
+				dec.comments = append(dec.comments, comment)
+			}
+			dec.lineStart = true
 		default:
-			dec.br.UnreadByte()
+			dec.unreadByte()
 			return nil
 		}
 	}
@@ -56,10 +193,14 @@ func (dec *decoder) readToken() (string, error) {
 	if err := dec.skipWhitespace(); err != nil {
 		return "", err
 	}
+	dec.doc = strings.Join(dec.comments, "\n")
+	dec.comments = nil
+	dec.lineStart = false
+	dec.tokenLine, dec.tokenCol = dec.line, dec.col+1
 
 	var sb strings.Builder
 	for {
-		ch, err := dec.br.ReadByte()
+		ch, err := dec.readByte()
 		if err == io.EOF && sb.Len() > 0 {
 			return sb.String(), nil
 		} else if err != nil {
@@ -68,7 +209,7 @@ func (dec *decoder) readToken() (string, error) {
 		switch ch {
 		case '?', '(', ')', ',', ':':
 			if sb.Len() > 0 {
-				dec.br.UnreadByte()
+				dec.unreadByte()
 				return sb.String(), nil
 			} else {
 				return string(ch), nil
@@ -77,7 +218,7 @@ func (dec *decoder) readToken() (string, error) {
 			sb.WriteByte(ch)
 			return sb.String(), nil
 		case ' ', '\t', '\r', '\n', '#':
-			dec.br.UnreadByte()
+			dec.unreadByte()
 			return sb.String(), nil
 		default:
 			sb.WriteByte(ch)
@@ -218,7 +359,7 @@ func (dec *decoder) readElementType(token string) (*Type, error) {
 	}
 
 	if token == "(" {
-		dec.br.UnreadByte()
+		dec.unreadByte()
 		return dec.readStructOrEnum()
 	}
 
@@ -226,6 +367,12 @@ func (dec *decoder) readElementType(token string) (*Type, error) {
 		return &Type{Kind: KindName, Name: token}, nil
 	}
 
+	if isFieldName(token) {
+		// Type names start with an uppercase letter, this is most likely a
+		// misspelled basic type
+		return nil, fmt.Errorf("unknown type %q", token)
+	}
+
 	return nil, fmt.Errorf("expected element type, got %q", token)
 }
 
@@ -266,15 +413,34 @@ func (dec *decoder) readType() (*Type, error) {
 	return &Type{Kind: kind, Inner: inner, Nullable: nullable}, nil
 }
 
+// readMemberName reads the name of a type, method or error, and checks that
+// it isn't already used by another member.
+func (dec *decoder) readMemberName(iface *Interface) (string, error) {
+	name, err := dec.readName()
+	if err != nil {
+		return "", err
+	}
+	_, isType := iface.Types[name]
+	_, isMethod := iface.Methods[name]
+	_, isError := iface.Errors[name]
+	if isType || isMethod || isError {
+		return "", fmt.Errorf("duplicate member %q", name)
+	}
+	return name, nil
+}
+
 func (dec *decoder) readMember(iface *Interface) error {
 	keyword, err := dec.readToken()
 	if err != nil {
 		return err
 	}
+	doc := dec.doc
+	line, col := dec.tokenLine, dec.tokenCol
 
+	var name string
 	switch keyword {
 	case "type":
-		name, err := dec.readName()
+		name, err = dec.readMemberName(iface)
 		if err != nil {
 			return err
 		}
@@ -284,7 +450,7 @@ func (dec *decoder) readMember(iface *Interface) error {
 		}
 		iface.Types[name] = *t
 	case "method":
-		name, err := dec.readName()
+		name, err = dec.readMemberName(iface)
 		if err != nil {
 			return err
 		}
@@ -301,7 +467,7 @@ func (dec *decoder) readMember(iface *Interface) error {
 		}
 		iface.Methods[name] = Method{In: in, Out: out}
 	case "error":
-		name, err := dec.readName()
+		name, err = dec.readMemberName(iface)
 		if err != nil {
 			return err
 		}
@@ -314,6 +480,20 @@ func (dec *decoder) readMember(iface *Interface) error {
 		return fmt.Errorf(`expected one of "type", "method", "error", got %q`, keyword)
 	}
 
+	if doc != "" {
+		iface.MemberDocs[name] = doc
+	} else {
+		dec.warn(line, col, "missing doc comment for %v %q", keyword, name)
+	}
+
+	if last, ok := dec.lastMember[keyword]; ok && name < last {
+		dec.warn(line, col, "%v %q is not sorted, it should come before %q", keyword, name, last)
+	}
+	if dec.lastMember == nil {
+		dec.lastMember = make(map[string]string)
+	}
+	dec.lastMember[keyword] = name
+
 	return nil
 }
 
@@ -321,15 +501,21 @@ func (dec *decoder) readInterface() (*Interface, error) {
 	if err := dec.expectToken("interface"); err != nil {
 		return nil, err
 	}
+	doc := dec.doc
+	if doc == "" {
+		dec.warn(dec.tokenLine, dec.tokenCol, "missing doc comment for interface")
+	}
 	name, err := dec.readInterfaceName()
 	if err != nil {
 		return nil, err
 	}
 	iface := &Interface{
-		Name:    name,
-		Types:   make(map[string]Type),
-		Methods: make(map[string]Method),
-		Errors:  make(map[string]Struct),
+		Name:       name,
+		Doc:        doc,
+		Types:      make(map[string]Type),
+		Methods:    make(map[string]Method),
+		Errors:     make(map[string]Struct),
+		MemberDocs: make(map[string]string),
 	}
 	for {
 		if err := dec.readMember(iface); err == io.EOF {
@@ -341,21 +527,19 @@ func (dec *decoder) readInterface() (*Interface, error) {
 	return iface, nil
 }
 
+// basicTypes maps the keywords of basic types to their kind. Tokens in type
+// position which are lowercase identifiers but not listed here are rejected as
+// unknown types.
+var basicTypes = map[string]Kind{
+	"bool":   KindBool,
+	"int":    KindInt,
+	"float":  KindFloat,
+	"string": KindString,
+	"object": KindObject,
+}
+
 func parseBasicType(token string) Kind {
-	switch token {
-	case "bool":
-		return KindBool
-	case "int":
-		return KindInt
-	case "float":
-		return KindFloat
-	case "string":
-		return KindString
-	case "object":
-		return KindObject
-	default:
-		return 0
-	}
+	return basicTypes[token]
 }
 
 func isInterfaceName(s string) bool {
@@ -1,9 +1,12 @@
 package varlinkdef_test
 
 import (
+	"errors"
+	"io"
 	"reflect"
 	"strings"
 	"testing"
+	"testing/iotest"
 
 	"github.com/emersion/go-varlink/varlinkdef"
 )
@@ -40,7 +43,16 @@ error InvalidParameter (parameter: string)
 `
 
 var serviceIface = &varlinkdef.Interface{
-	Name:  "org.varlink.service",
+	Name: "org.varlink.service",
+	Doc:  "The Varlink Service Interface is provided by every varlink service. It\ndescribes the service and the interfaces it implements.",
+	MemberDocs: map[string]string{
+		"GetInfo":                 "Get a list of all the interfaces a service provides and information\nabout the implementation.",
+		"GetInterfaceDescription": "Get the description of an interface that is implemented by this service.",
+		"InterfaceNotFound":       "The requested interface was not found.",
+		"MethodNotFound":          "The requested method was not found",
+		"MethodNotImplemented":    "The interface defines the requested method, but the service does not\nimplement it.",
+		"InvalidParameter":        "One of the passed parameters is invalid.",
+	},
 	Types: map[string]varlinkdef.Type{},
 	Methods: map[string]varlinkdef.Method{
 		"GetInfo": varlinkdef.Method{
@@ -138,6 +150,25 @@ error ParameterOutOfRange (field: string)
 
 var exampleIface = &varlinkdef.Interface{
 	Name: "org.example.ftl",
+	Doc: "Interface to jump a spacecraft to another point in space.\n" +
+		"The FTL Drive is the propulsion system to achieve\n" +
+		"faster-than-light travel through space. A ship making a\n" +
+		"properly calculated jump can arrive safely in planetary\n" +
+		"orbit, or alongside other ships or spaceborne objects.",
+	MemberDocs: map[string]string{
+		"DriveCondition":     "The current state of the FTL drive and the amount of\nfuel available to jump.",
+		"DriveConfiguration": "Speed, trajectory and jump duration is calculated prior\nto activating the FTL drive.",
+		"Coordinate": "The galactic coordinates use the Sun as the origin.\n" +
+			"Galactic longitude is measured with primary direction\n" +
+			"from the Sun to the center of the galaxy in the galactic\n" +
+			"plane, while the galactic latitude measures the angle\n" +
+			"of the object above the galactic plane.",
+		"Monitor":                "Monitor the drive. The method will reply with an update\nwhenever the drive's state changes",
+		"CalculateConfiguration": "Calculate the drive's jump parameters from the current\nposition to the target position in the galaxy",
+		"Jump":                   "Jump to the calculated point in space",
+		"NotEnoughEnergy":        "There is not enough tylium to jump with the given\nparameters",
+		"ParameterOutOfRange":    "The supplied parameters are outside the supported range",
+	},
 	Types: map[string]varlinkdef.Type{
 		"DriveCondition": varlinkdef.Type{
 			Kind: varlinkdef.KindStruct,
@@ -235,3 +266,240 @@ func TestRead(t *testing.T) {
 		})
 	}
 }
+
+func TestReadString(t *testing.T) {
+	iface, err := varlinkdef.ReadString(serviceRaw)
+	if err != nil {
+		t.Fatalf("ReadString() = %v", err)
+	}
+	if !reflect.DeepEqual(iface, serviceIface) {
+		t.Errorf("ReadString() = \n%#v\n but want \n%#v", iface, serviceIface)
+	}
+}
+
+func TestReadLimited(t *testing.T) {
+	if _, err := varlinkdef.ReadLimited(strings.NewReader(serviceRaw), int64(len(serviceRaw))); err != nil {
+		t.Errorf("ReadLimited() = %v", err)
+	}
+
+	raw := serviceRaw + "\n" + strings.Repeat("# padding\n", 1000)
+	if _, err := varlinkdef.ReadLimited(strings.NewReader(raw), int64(len(serviceRaw))); err == nil {
+		t.Errorf("ReadLimited() = nil, want an error for an oversized definition")
+	}
+}
+
+// stallReader returns (0, nil) once its data has been read.
+type stallReader struct {
+	r io.Reader
+}
+
+func (sr stallReader) Read(b []byte) (int, error) {
+	n, err := sr.r.Read(b)
+	if err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
+func TestReadLimited_stall(t *testing.T) {
+	r := stallReader{strings.NewReader(serviceRaw)}
+	if _, err := varlinkdef.ReadLimited(r, int64(len(serviceRaw))); err != nil {
+		t.Errorf("ReadLimited() = %v", err)
+	}
+}
+
+func TestReadName(t *testing.T) {
+	name, err := varlinkdef.ReadName(strings.NewReader(exampleRaw))
+	if err != nil {
+		t.Fatalf("ReadName() = %v", err)
+	} else if name != "org.example.ftl" {
+		t.Errorf("ReadName() = %q, want %q", name, "org.example.ftl")
+	}
+
+	// The rest of the definition is neither read nor parsed
+	i := strings.Index(exampleRaw, "org.example.ftl\n") + len("org.example.ftl\n")
+	r := io.MultiReader(strings.NewReader(exampleRaw[:i]), iotest.ErrReader(errors.New("read too far")))
+	if name, err := varlinkdef.ReadName(r); err != nil {
+		t.Errorf("ReadName() = %v", err)
+	} else if name != "org.example.ftl" {
+		t.Errorf("ReadName() = %q, want %q", name, "org.example.ftl")
+	}
+
+	for _, raw := range []string{"", "method Foo() -> ()\n", "interface 42\n"} {
+		if _, err := varlinkdef.ReadName(strings.NewReader(raw)); err == nil {
+			t.Errorf("ReadName(%q) = nil, want an error", raw)
+		}
+	}
+}
+
+const nestedRaw = `interface org.example.nested
+
+method Get() -> (
+  field: [string][](x: int),
+  deep: ?[]?[string](a: [](b: ?(c, d)), e: int)
+)
+`
+
+func TestRead_nested(t *testing.T) {
+
+	want := varlinkdef.Struct{
+		"field": varlinkdef.Type{
+			Kind: varlinkdef.KindMap,
+			Inner: &varlinkdef.Type{
+				Kind: varlinkdef.KindArray,
+				Inner: &varlinkdef.Type{
+					Kind: varlinkdef.KindStruct,
+					Struct: varlinkdef.Struct{
+						"x": varlinkdef.TypeInt,
+					},
+				},
+			},
+		},
+		"deep": varlinkdef.Type{
+			Kind:     varlinkdef.KindArray,
+			Nullable: true,
+			Inner: &varlinkdef.Type{
+				Kind:     varlinkdef.KindMap,
+				Nullable: true,
+				Inner: &varlinkdef.Type{
+					Kind: varlinkdef.KindStruct,
+					Struct: varlinkdef.Struct{
+						"a": varlinkdef.Type{
+							Kind: varlinkdef.KindArray,
+							Inner: &varlinkdef.Type{
+								Kind: varlinkdef.KindStruct,
+								Struct: varlinkdef.Struct{
+									"b": varlinkdef.Type{
+										Kind:     varlinkdef.KindEnum,
+										Nullable: true,
+										Enum:     varlinkdef.Enum{"c", "d"},
+									},
+								},
+							},
+						},
+						"e": varlinkdef.TypeInt,
+					},
+				},
+			},
+		},
+	}
+
+	iface, err := varlinkdef.ReadString(nestedRaw)
+	if err != nil {
+		t.Fatalf("ReadString() = %v", err)
+	}
+	if got := iface.Methods["Get"].Out; !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadString() = \n%#v\n but want \n%#v", got, want)
+	}
+}
+
+func TestRead_blankLinesAndComments(t *testing.T) {
+	const raw = `
+
+# Leading comment
+
+
+interface org.example.spacing
+
+
+
+# A comment block
+#
+# spanning several lines, with an empty comment line.
+method A() -> ()
+
+
+# First comment block.
+
+# Second comment block, separated by a blank line.
+#
+method B() -> ()
+# Comment right after a member
+type T (x: int)
+	
+  # Indented comment after a line with trailing whitespace
+error E ()
+
+
+# Trailing comment
+
+`
+
+	iface, err := varlinkdef.ReadString(raw)
+	if err != nil {
+		t.Fatalf("ReadString() = %v", err)
+	}
+	if len(iface.Methods) != 2 || len(iface.Types) != 1 || len(iface.Errors) != 1 {
+		t.Errorf("ReadString() = %v methods, %v types, %v errors, want 2, 1, 1", len(iface.Methods), len(iface.Types), len(iface.Errors))
+	}
+	for _, name := range []string{"A", "B"} {
+		if _, ok := iface.Methods[name]; !ok {
+			t.Errorf("method %q not found", name)
+		}
+	}
+}
+
+func TestRead_docs(t *testing.T) {
+	const raw = `# Interface doc.
+interface org.example.docs
+
+# Detached comment, followed by a blank line.
+
+# Type doc,
+#
+# with an empty line.
+type T (
+  # Field comments are not member docs.
+  x: int
+) # Trailing comment
+
+method NoDoc() -> () # Trailing comment
+# Method doc.
+method WithDoc() -> ()
+`
+
+	iface, err := varlinkdef.ReadString(raw)
+	if err != nil {
+		t.Fatalf("ReadString() = %v", err)
+	}
+	if iface.Doc != "Interface doc." {
+		t.Errorf("Doc = %q", iface.Doc)
+	}
+	want := map[string]string{
+		"T":       "Type doc,\n\nwith an empty line.",
+		"WithDoc": "Method doc.",
+	}
+	if !reflect.DeepEqual(iface.MemberDocs, want) {
+		t.Errorf("MemberDocs = %#v, want %#v", iface.MemberDocs, want)
+	}
+}
+
+func TestRead_duplicateMember(t *testing.T) {
+	members := map[string]string{
+		"type":   "type Foo (x: int)",
+		"method": "method Foo() -> ()",
+		"error":  "error Foo ()",
+	}
+
+	for firstKind, first := range members {
+		for secondKind, second := range members {
+			t.Run(firstKind+"-"+secondKind, func(t *testing.T) {
+				raw := "interface org.example.dup\n\n" + first + "\n\n" + second + "\n"
+				_, err := varlinkdef.ReadString(raw)
+				if err == nil || !strings.Contains(err.Error(), `duplicate member "Foo"`) {
+					t.Errorf("ReadString() = %v, want a duplicate member error", err)
+				}
+			})
+		}
+	}
+}
+
+func TestRead_unknownType(t *testing.T) {
+	for _, typ := range []string{"strng", "integer", "?boolean", "[]uint", "[string]double"} {
+		raw := "interface org.example.types\n\nmethod Get() -> (x: " + typ + ")\n"
+		_, err := varlinkdef.ReadString(raw)
+		if err == nil || !strings.Contains(err.Error(), "unknown type") {
+			t.Errorf("ReadString() with type %q = %v, want an unknown type error", typ, err)
+		}
+	}
+}
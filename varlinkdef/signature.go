@@ -0,0 +1,114 @@
+package varlinkdef
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/emersion/go-varlink/internal/naming"
+)
+
+// Field describes a struct field.
+type Field struct {
+	// Name is the Varlink field name.
+	Name string
+	// Type is a Go type which can represent the field. Inline enums are
+	// represented as strings.
+	Type string
+	// Nullable is true if the field is optional.
+	Nullable bool
+}
+
+// Signature returns descriptors for the method's input and output fields,
+// sorted by name.
+func (m Method) Signature() (in, out []Field, err error) {
+	if in, err = structFields(m.In); err != nil {
+		return nil, nil, fmt.Errorf("in input: %v", err)
+	}
+	if out, err = structFields(m.Out); err != nil {
+		return nil, nil, fmt.Errorf("in output: %v", err)
+	}
+	return in, out, nil
+}
+
+func structFields(st Struct) ([]Field, error) {
+	fields := make([]Field, 0, len(st))
+	for _, name := range sortedKeys(st) {
+		t := st[name]
+		typ, err := goType(&t)
+		if err != nil {
+			return nil, fmt.Errorf("in field %q: %v", name, err)
+		}
+		fields = append(fields, Field{
+			Name:     name,
+			Type:     typ,
+			Nullable: t.Nullable,
+		})
+	}
+	return fields, nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// goType returns a Go type for a Varlink type.
+func goType(t *Type) (string, error) {
+	if t.Nullable {
+		nt := *t
+		nt.Nullable = false
+		typ, err := goType(&nt)
+		if err != nil {
+			return "", err
+		}
+		return "*" + typ, nil
+	}
+
+	switch t.Kind {
+	case KindStruct:
+		if len(t.Struct) == 0 {
+			return "struct{}", nil
+		}
+		var fields []string
+		for _, name := range sortedKeys(t.Struct) {
+			ft := t.Struct[name]
+			typ, err := goType(&ft)
+			if err != nil {
+				return "", fmt.Errorf("in field %q: %v", name, err)
+			}
+			fields = append(fields, naming.GoName(name)+" "+typ)
+		}
+		return "struct { " + strings.Join(fields, "; ") + " }", nil
+	case KindEnum, KindString:
+		return "string", nil
+	case KindName:
+		return t.Name, nil
+	case KindBool:
+		return "bool", nil
+	case KindInt:
+		return "int", nil
+	case KindFloat:
+		return "float64", nil
+	case KindObject:
+		return "json.RawMessage", nil
+	case KindArray, KindMap:
+		if t.Inner == nil {
+			return "", fmt.Errorf("missing inner type")
+		}
+		typ, err := goType(t.Inner)
+		if err != nil {
+			return "", err
+		}
+		if t.Kind == KindArray {
+			return "[]" + typ, nil
+		}
+		return "map[string]" + typ, nil
+	default:
+		return "", fmt.Errorf("invalid kind %v", int(t.Kind))
+	}
+}
@@ -0,0 +1,91 @@
+package varlinkdef_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/emersion/go-varlink/varlinkdef"
+)
+
+func TestMethod_Signature(t *testing.T) {
+	tests := []struct {
+		Name    string
+		Method  varlinkdef.Method
+		In, Out []varlinkdef.Field
+	}{
+		{
+			Name:   "GetInfo",
+			Method: serviceIface.Methods["GetInfo"],
+			In:     []varlinkdef.Field{},
+			Out: []varlinkdef.Field{
+				{Name: "interfaces", Type: "[]string"},
+				{Name: "product", Type: "string"},
+				{Name: "url", Type: "string"},
+				{Name: "vendor", Type: "string"},
+				{Name: "version", Type: "string"},
+			},
+		},
+		{
+			Name:   "CalculateConfiguration",
+			Method: exampleIface.Methods["CalculateConfiguration"],
+			In: []varlinkdef.Field{
+				{Name: "current", Type: "Coordinate"},
+				{Name: "target", Type: "Coordinate"},
+			},
+			Out: []varlinkdef.Field{
+				{Name: "configuration", Type: "DriveConfiguration"},
+			},
+		},
+		{
+			Name: "Nested",
+			Method: varlinkdef.Method{
+				In: varlinkdef.Struct{
+					"tags": varlinkdef.Type{
+						Kind:     varlinkdef.KindMap,
+						Nullable: true,
+						Inner:    &varlinkdef.TypeObject,
+					},
+					"point": varlinkdef.Type{
+						Kind: varlinkdef.KindStruct,
+						Struct: varlinkdef.Struct{
+							"x_pos": varlinkdef.TypeFloat,
+							"y_pos": varlinkdef.TypeFloat,
+						},
+					},
+				},
+				Out: varlinkdef.Struct{},
+			},
+			In: []varlinkdef.Field{
+				{Name: "point", Type: "struct { XPos float64; YPos float64 }"},
+				{Name: "tags", Type: "*map[string]json.RawMessage", Nullable: true},
+			},
+			Out: []varlinkdef.Field{},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			in, out, err := tc.Method.Signature()
+			if err != nil {
+				t.Fatalf("Signature() = %v", err)
+			}
+			if !reflect.DeepEqual(in, tc.In) {
+				t.Errorf("Signature() in = %#v, want %#v", in, tc.In)
+			}
+			if !reflect.DeepEqual(out, tc.Out) {
+				t.Errorf("Signature() out = %#v, want %#v", out, tc.Out)
+			}
+		})
+	}
+}
+
+func TestMethod_Signature_invalidKind(t *testing.T) {
+	m := varlinkdef.Method{
+		In: varlinkdef.Struct{
+			"list": varlinkdef.Type{Kind: varlinkdef.KindArray, Inner: &varlinkdef.Type{}},
+		},
+	}
+	if _, _, err := m.Signature(); err == nil {
+		t.Errorf("Signature() = nil, want an error")
+	}
+}
@@ -0,0 +1,108 @@
+package varlinkdef
+
+import (
+	"fmt"
+)
+
+// Validate checks that all named type references in the interface resolve to
+// a type declared in the interface.
+//
+// The returned error describes where the first undefined reference was
+// found. Members are checked in sorted order.
+func (iface *Interface) Validate() error {
+	for _, name := range sortedKeys(iface.Types) {
+		typ := iface.Types[name]
+		if err := iface.validateType(&typ); err != nil {
+			return fmt.Errorf("in type %q: %v", name, err)
+		}
+	}
+	for _, name := range sortedKeys(iface.Methods) {
+		method := iface.Methods[name]
+		if err := iface.validateStruct(method.In); err != nil {
+			return fmt.Errorf("in method %q input: %v", name, err)
+		}
+		if err := iface.validateStruct(method.Out); err != nil {
+			return fmt.Errorf("in method %q output: %v", name, err)
+		}
+	}
+	for _, name := range sortedKeys(iface.Errors) {
+		if err := iface.validateStruct(iface.Errors[name]); err != nil {
+			return fmt.Errorf("in error %q: %v", name, err)
+		}
+	}
+	return nil
+}
+
+func (iface *Interface) validateStruct(st Struct) error {
+	for _, name := range sortedKeys(st) {
+		t := st[name]
+		if err := iface.validateType(&t); err != nil {
+			return fmt.Errorf("in field %q: %v", name, err)
+		}
+	}
+	return nil
+}
+
+func (iface *Interface) validateType(t *Type) error {
+	switch t.Kind {
+	case KindStruct:
+		return iface.validateStruct(t.Struct)
+	case KindName:
+		if _, ok := iface.Types[t.Name]; !ok {
+			return fmt.Errorf("undefined type %q", t.Name)
+		}
+	case KindArray, KindMap:
+		if t.Inner == nil {
+			return fmt.Errorf("missing inner type for %v", t.Kind)
+		}
+		return iface.validateType(t.Inner)
+	}
+	return nil
+}
+
+// UnusedTypes returns the sorted names of types declared in the interface but
+// never referenced by a method, an error, or another referenced type.
+func (iface *Interface) UnusedTypes() []string {
+	used := make(map[string]bool)
+	for _, method := range iface.Methods {
+		iface.markStruct(used, method.In)
+		iface.markStruct(used, method.Out)
+	}
+	for _, st := range iface.Errors {
+		iface.markStruct(used, st)
+	}
+
+	var unused []string
+	for _, name := range sortedKeys(iface.Types) {
+		if !used[name] {
+			unused = append(unused, name)
+		}
+	}
+	return unused
+}
+
+func (iface *Interface) markStruct(used map[string]bool, st Struct) {
+	for _, t := range st {
+		t := t
+		iface.markType(used, &t)
+	}
+}
+
+func (iface *Interface) markType(used map[string]bool, t *Type) {
+	switch t.Kind {
+	case KindStruct:
+		iface.markStruct(used, t.Struct)
+	case KindName:
+		if used[t.Name] {
+			return
+		}
+		used[t.Name] = true
+		if named, ok := iface.Types[t.Name]; ok {
+			iface.markType(used, &named)
+		}
+	case KindArray, KindMap:
+		if t.Inner != nil {
+			iface.markType(used, t.Inner)
+		}
+	}
+}
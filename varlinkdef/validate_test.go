@@ -0,0 +1,84 @@
+package varlinkdef_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-varlink/varlinkdef"
+)
+
+func TestInterface_Validate(t *testing.T) {
+	for _, raw := range []string{serviceRaw, exampleRaw, nestedRaw} {
+		iface, err := varlinkdef.ReadString(raw)
+		if err != nil {
+			t.Fatalf("ReadString() = %v", err)
+		}
+		if err := iface.Validate(); err != nil {
+			t.Errorf("%v: Validate() = %v", iface.Name, err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		raw  string
+		want string
+	}{
+		{"methodInput", "method Get(p: Point) -> ()", `in method "Get" input: in field "p": undefined type "Point"`},
+		{"methodOutput", "method Get() -> (p: ?[]Point)", `in method "Get" output: in field "p": undefined type "Point"`},
+		{"error", "error Failed (at: [string]Point)", `in error "Failed": in field "at": undefined type "Point"`},
+		{"nestedType", "type Line (from: (p: Point))", `in type "Line": in field "from": in field "p": undefined type "Point"`},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			iface, err := varlinkdef.ReadString("interface org.example.broken\n\n" + tc.raw + "\n")
+			if err != nil {
+				t.Fatalf("ReadString() = %v", err)
+			}
+			err = iface.Validate()
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("Validate() = %v, want %v", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestInterface_Validate_missingInner(t *testing.T) {
+	iface := &varlinkdef.Interface{
+		Name: "org.example.broken",
+		Methods: map[string]varlinkdef.Method{
+			"Get": {In: varlinkdef.Struct{}, Out: varlinkdef.Struct{
+				"list": {Kind: varlinkdef.KindArray, Nullable: true},
+			}},
+		},
+	}
+	want := `in method "Get" output: in field "list": missing inner type for array`
+	if err := iface.Validate(); err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("Validate() = %v, want %v", err, want)
+	}
+	if got := iface.UnusedTypes(); len(got) != 0 {
+		t.Errorf("UnusedTypes() = %v, want none", got)
+	}
+}
+
+func TestInterface_UnusedTypes(t *testing.T) {
+	iface, err := varlinkdef.ReadString(`interface org.example.unused
+
+type Point (x: int, y: int)
+type Line (from: Point, to: Point)
+type Color (red, green)
+type Orphan (x: int)
+
+method Draw(lines: []Line) -> ()
+
+error BadColor (color: Color)
+`)
+	if err != nil {
+		t.Fatalf("ReadString() = %v", err)
+	}
+
+	if got, want := iface.UnusedTypes(), []string{"Orphan"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("UnusedTypes() = %v, want %v", got, want)
+	}
+}
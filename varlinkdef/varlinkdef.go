@@ -12,6 +12,13 @@ type Interface struct {
 	Types   map[string]Type // only KindStruct and KindEnum
 	Methods map[string]Method
 	Errors  map[string]Struct
+
+	// Documentation comments, without the leading "#". Doc is the comment
+	// block preceding the interface keyword, MemberDocs maps type, method and
+	// error names to the comment block preceding them. Comment blocks must
+	// not be separated from what they document by a blank line.
+	Doc        string
+	MemberDocs map[string]string
 }
 
 type Method struct {
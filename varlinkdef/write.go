@@ -0,0 +1,120 @@
+package varlinkdef
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Write writes the textual form of a Varlink interface definition.
+//
+// The output is canonical: members are grouped by kind (types, methods, then
+// errors) and sorted by name, and struct fields are sorted by name and written
+// on a single line. Documentation comments are written before the interface
+// and each member, other comments are not preserved.
+func Write(w io.Writer, iface *Interface) error {
+	var sb strings.Builder
+	writeDoc(&sb, iface.Doc)
+	sb.WriteString("interface " + iface.Name + "\n")
+
+	for _, name := range sortedKeys(iface.Types) {
+		typ := iface.Types[name]
+		if typ.Kind != KindStruct && typ.Kind != KindEnum {
+			return fmt.Errorf("varlinkdef: type %q: expected struct or enum, got %v", name, typ.Kind)
+		}
+		sb.WriteString("\n")
+		writeDoc(&sb, iface.MemberDocs[name])
+		sb.WriteString("type " + name + " ")
+		if err := writeType(&sb, &typ); err != nil {
+			return fmt.Errorf("varlinkdef: type %q: %v", name, err)
+		}
+		sb.WriteString("\n")
+	}
+
+	for _, name := range sortedKeys(iface.Methods) {
+		method := iface.Methods[name]
+		sb.WriteString("\n")
+		writeDoc(&sb, iface.MemberDocs[name])
+		sb.WriteString("method " + name)
+		if err := writeStruct(&sb, method.In); err != nil {
+			return fmt.Errorf("varlinkdef: method %q: %v", name, err)
+		}
+		sb.WriteString(" -> ")
+		if err := writeStruct(&sb, method.Out); err != nil {
+			return fmt.Errorf("varlinkdef: method %q: %v", name, err)
+		}
+		sb.WriteString("\n")
+	}
+
+	for _, name := range sortedKeys(iface.Errors) {
+		sb.WriteString("\n")
+		writeDoc(&sb, iface.MemberDocs[name])
+		sb.WriteString("error " + name + " ")
+		if err := writeStruct(&sb, iface.Errors[name]); err != nil {
+			return fmt.Errorf("varlinkdef: error %q: %v", name, err)
+		}
+		sb.WriteString("\n")
+	}
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func writeDoc(sb *strings.Builder, doc string) {
+	if doc == "" {
+		return
+	}
+	for _, line := range strings.Split(doc, "\n") {
+		if line == "" {
+			sb.WriteString("#\n")
+		} else {
+			sb.WriteString("# " + line + "\n")
+		}
+	}
+}
+
+func writeStruct(sb *strings.Builder, st Struct) error {
+	sb.WriteString("(")
+	for i, name := range sortedKeys(st) {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		t := st[name]
+		sb.WriteString(name + ": ")
+		if err := writeType(sb, &t); err != nil {
+			return fmt.Errorf("field %q: %v", name, err)
+		}
+	}
+	sb.WriteString(")")
+	return nil
+}
+
+func writeType(sb *strings.Builder, t *Type) error {
+	if t.Nullable {
+		sb.WriteString("?")
+	}
+
+	switch t.Kind {
+	case KindStruct:
+		return writeStruct(sb, t.Struct)
+	case KindEnum:
+		sb.WriteString("(" + strings.Join(t.Enum, ", ") + ")")
+	case KindName:
+		sb.WriteString(t.Name)
+	case KindBool, KindInt, KindFloat, KindString, KindObject:
+		sb.WriteString(t.Kind.String())
+	case KindArray, KindMap:
+		if t.Inner == nil {
+			return fmt.Errorf("missing inner type for %v", t.Kind)
+		}
+		if t.Kind == KindArray {
+			sb.WriteString("[]")
+		} else {
+			sb.WriteString("[string]")
+		}
+		return writeType(sb, t.Inner)
+	default:
+		return fmt.Errorf("invalid kind %v", int(t.Kind))
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package varlinkdef_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-varlink/varlinkdef"
+)
+
+func TestWrite(t *testing.T) {
+	iface := &varlinkdef.Interface{
+		Name: "org.example.write",
+		Types: map[string]varlinkdef.Type{
+			"Mode": {Kind: varlinkdef.KindEnum, Enum: varlinkdef.Enum{"fast", "slow"}},
+			"Point": {Kind: varlinkdef.KindStruct, Struct: varlinkdef.Struct{
+				"y": varlinkdef.TypeFloat,
+				"x": varlinkdef.TypeFloat,
+			}},
+		},
+		Methods: map[string]varlinkdef.Method{
+			"Move": {
+				In: varlinkdef.Struct{
+					"to":   {Kind: varlinkdef.KindName, Name: "Point"},
+					"mode": {Kind: varlinkdef.KindName, Name: "Mode", Nullable: true},
+					"tags": {Kind: varlinkdef.KindMap, Inner: &varlinkdef.Type{Kind: varlinkdef.KindArray, Inner: &varlinkdef.TypeString}},
+				},
+				Out: varlinkdef.Struct{},
+			},
+		},
+		Errors: map[string]varlinkdef.Struct{
+			"Blocked": {"by": {Kind: varlinkdef.KindStruct, Struct: varlinkdef.Struct{"name": varlinkdef.TypeString}}},
+		},
+	}
+
+	const want = `interface org.example.write
+
+type Mode (fast, slow)
+
+type Point (x: float, y: float)
+
+method Move(mode: ?Mode, tags: [string][]string, to: Point) -> ()
+
+error Blocked (by: (name: string))
+`
+
+	var sb strings.Builder
+	if err := varlinkdef.Write(&sb, iface); err != nil {
+		t.Fatalf("Write() = %v", err)
+	}
+	if got := sb.String(); got != want {
+		t.Errorf("Write() = \n%v\n but want \n%v", got, want)
+	}
+}
+
+func TestWrite_roundTrip(t *testing.T) {
+	tests := []struct {
+		Name string
+		Raw  string
+	}{
+		{"org.varlink.service", serviceRaw},
+		{"org.example.ftl", exampleRaw},
+		{"org.example.nested", nestedRaw},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			iface, err := varlinkdef.ReadString(tc.Raw)
+			if err != nil {
+				t.Fatalf("ReadString() = %v", err)
+			}
+
+			var sb strings.Builder
+			if err := varlinkdef.Write(&sb, iface); err != nil {
+				t.Fatalf("Write() = %v", err)
+			}
+
+			got, err := varlinkdef.ReadString(sb.String())
+			if err != nil {
+				t.Fatalf("ReadString() = %v, for:\n%v", err, sb.String())
+			}
+			if !reflect.DeepEqual(got, iface) {
+				t.Errorf("ReadString(Write()) = \n%#v\n but want \n%#v", got, iface)
+			}
+		})
+	}
+}
+
+func TestWrite_invalid(t *testing.T) {
+	iface := &varlinkdef.Interface{
+		Name: "org.example.invalid",
+		Methods: map[string]varlinkdef.Method{
+			"Get": {Out: varlinkdef.Struct{"list": {Kind: varlinkdef.KindArray}}},
+		},
+	}
+	if err := varlinkdef.Write(&strings.Builder{}, iface); err == nil {
+		t.Errorf("Write() = nil, want an error for an array without inner type")
+	}
+}
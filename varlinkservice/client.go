@@ -0,0 +1,34 @@
+package varlinkservice
+
+import (
+	"fmt"
+	"strings"
+)
+
+// GetAllInterfaceDescriptions fetches the description of all interfaces
+// implemented by the service.
+//
+// If some descriptions cannot be fetched, the successful ones are returned
+// alongside an error.
+func (c Client) GetAllInterfaceDescriptions() (map[string]string, error) {
+	info, err := c.GetInfo(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	descs := make(map[string]string, len(info.Interfaces))
+	var errs []string
+	for _, name := range info.Interfaces {
+		out, err := c.GetInterfaceDescription(&GetInterfaceDescriptionIn{Interface: name})
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%v: %v", name, err))
+			continue
+		}
+		descs[name] = out.Description
+	}
+
+	if len(errs) > 0 {
+		return descs, fmt.Errorf("varlink: failed to get interface descriptions: %v", strings.Join(errs, "; "))
+	}
+	return descs, nil
+}
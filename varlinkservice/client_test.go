@@ -0,0 +1,159 @@
+package varlinkservice_test
+
+import (
+	"bufio"
+	"net"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/emersion/go-varlink"
+	"github.com/emersion/go-varlink/varlinkservice"
+	"github.com/emersion/go-varlink/varlinktest"
+)
+
+type backend struct {
+	descs map[string]string
+}
+
+func (be *backend) GetInfo(in *varlinkservice.GetInfoIn) (*varlinkservice.GetInfoOut, error) {
+	return &varlinkservice.GetInfoOut{
+		Interfaces: []string{"org.varlink.service", "org.example.ftl", "org.example.missing"},
+	}, nil
+}
+
+func (be *backend) GetInterfaceDescription(in *varlinkservice.GetInterfaceDescriptionIn) (*varlinkservice.GetInterfaceDescriptionOut, error) {
+	desc, ok := be.descs[in.Interface]
+	if !ok {
+		return nil, &varlinkservice.InterfaceNotFoundError{Interface: in.Interface}
+	}
+	return &varlinkservice.GetInterfaceDescriptionOut{Description: desc}, nil
+}
+
+func newTestClient(t *testing.T, be varlinkservice.Backend) varlinkservice.Client {
+	return varlinkservice.Client{Client: varlinktest.NewClient(t, varlinkservice.Handler{Backend: be})}
+}
+
+func TestClient_GetAllInterfaceDescriptions(t *testing.T) {
+	descs := map[string]string{
+		"org.varlink.service": "interface org.varlink.service\n",
+		"org.example.ftl":     "interface org.example.ftl\n",
+	}
+	c := newTestClient(t, &backend{descs: descs})
+
+	got, err := c.GetAllInterfaceDescriptions()
+	if err == nil {
+		t.Errorf("GetAllInterfaceDescriptions() succeeded with a missing interface")
+	}
+	if !reflect.DeepEqual(got, descs) {
+		t.Errorf("GetAllInterfaceDescriptions() = %v, want %v", got, descs)
+	}
+}
+
+func TestHandler_notFound(t *testing.T) {
+	c := newTestClient(t, &backend{})
+
+	tests := []struct {
+		method, errName string
+	}{
+		{"org.varlink.service.Unknown", "org.varlink.service.MethodNotFound"},
+		{"org.example.unknown.GetInfo", "org.varlink.service.InterfaceNotFound"},
+	}
+	for _, tc := range tests {
+		err := c.Client.Do(tc.method, nil, nil)
+		verr, ok := err.(*varlink.ClientError)
+		if !ok {
+			t.Errorf("Do(%q) = %v, want a *varlink.ClientError", tc.method, err)
+		} else if verr.Name != tc.errName {
+			t.Errorf("Do(%q) = %v, want %v", tc.method, verr.Name, tc.errName)
+		}
+	}
+}
+
+// errorBackend fails GetInterfaceDescription with the error at the index
+// given by the interface name.
+type errorBackend []error
+
+func (be errorBackend) GetInfo(in *varlinkservice.GetInfoIn) (*varlinkservice.GetInfoOut, error) {
+	return &varlinkservice.GetInfoOut{}, nil
+}
+
+func (be errorBackend) GetInterfaceDescription(in *varlinkservice.GetInterfaceDescriptionIn) (*varlinkservice.GetInterfaceDescriptionOut, error) {
+	i, err := strconv.Atoi(in.Interface)
+	if err != nil {
+		return nil, err
+	}
+	return nil, be[i]
+}
+
+func TestErrors_roundTrip(t *testing.T) {
+	errs := errorBackend{
+		&varlinkservice.ExpectedMoreError{},
+		&varlinkservice.InterfaceNotFoundError{Interface: "org.example.ftl"},
+		&varlinkservice.InvalidParameterError{Parameter: "interface"},
+		&varlinkservice.MethodNotFoundError{Method: "org.example.ftl.Jump"},
+		&varlinkservice.MethodNotImplementedError{Method: "org.example.ftl.Jump"},
+		&varlinkservice.PermissionDeniedError{},
+	}
+
+	c := newTestClient(t, errs)
+	for i, want := range errs {
+		_, err := c.GetInterfaceDescription(&varlinkservice.GetInterfaceDescriptionIn{
+			Interface: strconv.Itoa(i),
+		})
+		if !reflect.DeepEqual(err, want) {
+			t.Errorf("GetInterfaceDescription() = %#v, want %#v", err, want)
+		}
+	}
+}
+
+func TestClient_errorWithoutParameters(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer serverConn.Close()
+
+	c := varlinkservice.Client{Client: varlink.NewClient(clientConn)}
+	defer c.Close()
+
+	go func() {
+		br := bufio.NewReader(serverConn)
+		if _, err := br.ReadBytes(0); err != nil {
+			return
+		}
+		serverConn.Write([]byte(`{"error":"org.varlink.service.ExpectedMore","parameters":null}` + "\x00"))
+		if _, err := br.ReadBytes(0); err != nil {
+			return
+		}
+		serverConn.Write([]byte(`{"error":"org.varlink.service.ExpectedMore"}` + "\x00"))
+	}()
+
+	for i := 0; i < 2; i++ {
+		_, err := c.GetInfo(nil)
+		if _, ok := err.(*varlinkservice.ExpectedMoreError); !ok {
+			t.Errorf("GetInfo() = %v, want *ExpectedMoreError", err)
+		}
+	}
+}
+
+func TestServer_healthCheck(t *testing.T) {
+	srv := varlink.NewServer()
+	srv.Handler = varlinkservice.Handler{Backend: &backend{}}
+	if srv.Healthy() {
+		t.Errorf("Healthy() = true before Serve")
+	}
+
+	ts := varlinktest.NewServer(t, srv)
+	c := varlinkservice.Client{Client: ts.Dial(t)}
+
+	// Once a connection has been served, Serve is running
+	if _, err := c.GetInfo(nil); err != nil {
+		t.Errorf("GetInfo() = %v", err)
+	}
+	if !srv.Healthy() {
+		t.Errorf("Healthy() = false while serving")
+	}
+
+	ts.Close()
+	if srv.Healthy() {
+		t.Errorf("Healthy() = true after Serve returned")
+	}
+}
@@ -1,18 +1,29 @@
 // Code generated by go-varlink/varlinkgen. DO NOT EDIT.
 
+// Package varlinkservice implements the org.varlink.service Varlink interface.
+//
+// The Varlink Service Interface is provided by every varlink service. It
+// describes the service and the interfaces it implements.
 package varlinkservice
 
 import (
 	"encoding/json"
+	"fmt"
 	govarlink "github.com/emersion/go-varlink"
+	"strings"
 )
 
+// Method is expected to be called with 'more' set to true, but wasn't
 type ExpectedMoreError struct{}
 
 func (err *ExpectedMoreError) Error() string {
 	return "varlink call failed: org.varlink.service.ExpectedMore"
 }
+func NewExpectedMoreError() *ExpectedMoreError {
+	return &ExpectedMoreError{}
+}
 
+// The requested interface was not found.
 type InterfaceNotFoundError struct {
 	Interface string `json:"interface"`
 }
@@ -20,7 +31,11 @@ type InterfaceNotFoundError struct {
 func (err *InterfaceNotFoundError) Error() string {
 	return "varlink call failed: org.varlink.service.InterfaceNotFound"
 }
+func NewInterfaceNotFoundError(interface_ string) *InterfaceNotFoundError {
+	return &InterfaceNotFoundError{Interface: interface_}
+}
 
+// One of the passed parameters is invalid.
 type InvalidParameterError struct {
 	Parameter string `json:"parameter"`
 }
@@ -28,7 +43,11 @@ type InvalidParameterError struct {
 func (err *InvalidParameterError) Error() string {
 	return "varlink call failed: org.varlink.service.InvalidParameter"
 }
+func NewInvalidParameterError(parameter string) *InvalidParameterError {
+	return &InvalidParameterError{Parameter: parameter}
+}
 
+// The requested method was not found
 type MethodNotFoundError struct {
 	Method string `json:"method"`
 }
@@ -36,7 +55,12 @@ type MethodNotFoundError struct {
 func (err *MethodNotFoundError) Error() string {
 	return "varlink call failed: org.varlink.service.MethodNotFound"
 }
+func NewMethodNotFoundError(method string) *MethodNotFoundError {
+	return &MethodNotFoundError{Method: method}
+}
 
+// The interface defines the requested method, but the service does not
+// implement it.
 type MethodNotImplementedError struct {
 	Method string `json:"method"`
 }
@@ -44,29 +68,63 @@ type MethodNotImplementedError struct {
 func (err *MethodNotImplementedError) Error() string {
 	return "varlink call failed: org.varlink.service.MethodNotImplemented"
 }
+func NewMethodNotImplementedError(method string) *MethodNotImplementedError {
+	return &MethodNotImplementedError{Method: method}
+}
 
+// Client is denied access
 type PermissionDeniedError struct{}
 
 func (err *PermissionDeniedError) Error() string {
 	return "varlink call failed: org.varlink.service.PermissionDenied"
 }
+func NewPermissionDeniedError() *PermissionDeniedError {
+	return &PermissionDeniedError{}
+}
 
+// Get a list of all the interfaces a service provides and information
+// about the implementation.
 type GetInfoIn struct{}
+
+func (v *GetInfoIn) Validate() error {
+	return nil
+}
+
+// Get a list of all the interfaces a service provides and information
+// about the implementation.
 type GetInfoOut struct {
 	Interfaces []string `json:"interfaces"`
 	Product    string   `json:"product"`
-	Url        string   `json:"url"`
+	URL        string   `json:"url"`
 	Vendor     string   `json:"vendor"`
 	Version    string   `json:"version"`
 }
 
+func (v *GetInfoOut) Validate() error {
+	if v.Interfaces == nil {
+		return fmt.Errorf("missing field %q", "interfaces")
+	}
+	return nil
+}
+
+// Get the description of an interface that is implemented by this service.
 type GetInterfaceDescriptionIn struct {
 	Interface string `json:"interface"`
 }
+
+func (v *GetInterfaceDescriptionIn) Validate() error {
+	return nil
+}
+
+// Get the description of an interface that is implemented by this service.
 type GetInterfaceDescriptionOut struct {
 	Description string `json:"description"`
 }
 
+func (v *GetInterfaceDescriptionOut) Validate() error {
+	return nil
+}
+
 type Client struct {
 	*govarlink.Client
 }
@@ -93,11 +151,18 @@ func unmarshalError(err error) error {
 	default:
 		return err
 	}
-	if err := json.Unmarshal(verr.Parameters, v); err != nil {
+	params := verr.Parameters
+	if len(params) == 0 {
+		params = json.RawMessage("{}")
+	}
+	if err := json.Unmarshal(params, v); err != nil {
 		return err
 	}
 	return v
 }
+
+// Get a list of all the interfaces a service provides and information
+// about the implementation.
 func (c Client) GetInfo(in *GetInfoIn) (*GetInfoOut, error) {
 	if in == nil {
 		in = new(GetInfoIn)
@@ -106,6 +171,8 @@ func (c Client) GetInfo(in *GetInfoIn) (*GetInfoOut, error) {
 	err := c.Client.Do("org.varlink.service.GetInfo", in, out)
 	return out, unmarshalError(err)
 }
+
+// Get the description of an interface that is implemented by this service.
 func (c Client) GetInterfaceDescription(in *GetInterfaceDescriptionIn) (*GetInterfaceDescriptionOut, error) {
 	if in == nil {
 		in = new(GetInterfaceDescriptionIn)
@@ -115,11 +182,43 @@ func (c Client) GetInterfaceDescription(in *GetInterfaceDescriptionIn) (*GetInte
 	return out, unmarshalError(err)
 }
 
+type ClientInterface interface {
+	// Get a list of all the interfaces a service provides and information
+	// about the implementation.
+	GetInfo(*GetInfoIn) (*GetInfoOut, error)
+	// Get the description of an interface that is implemented by this service.
+	GetInterfaceDescription(*GetInterfaceDescriptionIn) (*GetInterfaceDescriptionOut, error)
+}
+
+var _ ClientInterface = (*Client)(nil)
+
 type Backend interface {
+	// Get a list of all the interfaces a service provides and information
+	// about the implementation.
 	GetInfo(*GetInfoIn) (*GetInfoOut, error)
+	// Get the description of an interface that is implemented by this service.
 	GetInterfaceDescription(*GetInterfaceDescriptionIn) (*GetInterfaceDescriptionOut, error)
 }
 
+type UnimplementedBackend struct{}
+
+var _ Backend = UnimplementedBackend{}
+
+func (UnimplementedBackend) GetInfo(*GetInfoIn) (*GetInfoOut, error) {
+	return nil, &govarlink.ServerError{
+		Name:       "org.varlink.service.MethodNotImplemented",
+		Parameters: map[string]string{"method": "org.varlink.service.GetInfo"},
+	}
+}
+func (UnimplementedBackend) GetInterfaceDescription(*GetInterfaceDescriptionIn) (*GetInterfaceDescriptionOut, error) {
+	return nil, &govarlink.ServerError{
+		Name:       "org.varlink.service.MethodNotImplemented",
+		Parameters: map[string]string{"method": "org.varlink.service.GetInterfaceDescription"},
+	}
+}
+
+// The Varlink Service Interface is provided by every varlink service. It
+// describes the service and the interfaces it implements.
 type Handler struct {
 	Backend Backend
 }
@@ -166,9 +265,20 @@ func (h Handler) HandleVarlink(call *govarlink.ServerCall, req *govarlink.Server
 		}
 		out, err = h.Backend.GetInterfaceDescription(in)
 	default:
-		err = &govarlink.ServerError{
-			Name:       "org.varlink.service.MethodNotFound",
-			Parameters: map[string]string{"method": req.Method},
+		ifaceName := req.Method
+		if i := strings.LastIndexByte(ifaceName, '.'); i >= 0 {
+			ifaceName = ifaceName[:i]
+		}
+		if ifaceName == "org.varlink.service" {
+			err = &govarlink.ServerError{
+				Name:       "org.varlink.service.MethodNotFound",
+				Parameters: map[string]string{"method": req.Method},
+			}
+		} else {
+			err = &govarlink.ServerError{
+				Name:       "org.varlink.service.InterfaceNotFound",
+				Parameters: map[string]string{"interface": ifaceName},
+			}
 		}
 	}
 	if err != nil {
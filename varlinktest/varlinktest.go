@@ -0,0 +1,141 @@
+// Package varlinktest provides utilities for Varlink testing.
+package varlinktest
+
+import (
+	"encoding/json"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/emersion/go-varlink"
+)
+
+// RecordedCall is a call received by a RecordingHandler.
+type RecordedCall struct {
+	Method     string
+	Parameters json.RawMessage
+	More       bool
+	Oneway     bool
+}
+
+// Reply is a canned reply sent by a RecordingHandler.
+type Reply struct {
+	// Reply parameters, marshaled to a JSON object.
+	Parameters interface{}
+	// If non-nil, the error is sent instead of the parameters. Only valid
+	// for the last reply of a call.
+	Error *varlink.ServerError
+}
+
+// RecordingHandler is a Handler recording all calls it receives and replying
+// with canned replies. It is meant to test clients without implementing a
+// service.
+type RecordingHandler struct {
+	// Replies sent for each call, by fully qualified method name. All replies
+	// are sent to calls with more set, only the last one is sent to other
+	// calls. If a method has no replies, an empty reply is sent. Replies must
+	// not be modified while the handler is serving calls.
+	Replies map[string][]Reply
+	// Calls received so far. Calls must only be accessed directly when the
+	// handler is no longer serving calls, use RecordedCalls otherwise.
+	Calls []RecordedCall
+
+	mutex sync.Mutex
+}
+
+var _ varlink.Handler = (*RecordingHandler)(nil)
+
+// HandleVarlink implements varlink.Handler.
+func (h *RecordingHandler) HandleVarlink(call *varlink.ServerCall, req *varlink.ServerRequest) error {
+	h.mutex.Lock()
+	h.Calls = append(h.Calls, RecordedCall{
+		Method:     req.Method,
+		Parameters: append(json.RawMessage(nil), req.Parameters...),
+		More:       req.More,
+		Oneway:     req.Oneway,
+	})
+	h.mutex.Unlock()
+
+	replies := h.Replies[req.Method]
+	if len(replies) == 0 {
+		return call.CloseWithReply(nil)
+	}
+	if !req.More {
+		replies = replies[len(replies)-1:]
+	}
+
+	for _, reply := range replies[:len(replies)-1] {
+		if err := call.Reply(reply.Parameters); err != nil {
+			return err
+		}
+	}
+
+	last := replies[len(replies)-1]
+	if last.Error != nil {
+		return last.Error
+	}
+	return call.CloseWithReply(last.Parameters)
+}
+
+// RecordedCalls returns a copy of the calls received so far. It is safe to
+// call while the handler is serving calls.
+func (h *RecordingHandler) RecordedCalls() []RecordedCall {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return append([]RecordedCall(nil), h.Calls...)
+}
+
+// Server is a Varlink server listening on a local TCP port, for use in tests.
+type Server struct {
+	*varlink.Server
+	Listener net.Listener
+
+	done chan struct{}
+}
+
+// NewServer starts serving srv on a local TCP port. The server is closed when
+// the test ends.
+func NewServer(t testing.TB, srv *varlink.Server) *Server {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen() = %v", err)
+	}
+
+	s := &Server{Server: srv, Listener: ln, done: make(chan struct{})}
+	go func() {
+		srv.Serve(ln)
+		close(s.done)
+	}()
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Close closes the listener and waits for Serve to return. Connections which
+// have already been accepted are left running.
+func (s *Server) Close() {
+	s.Listener.Close()
+	<-s.done
+}
+
+// Dial returns a new client connected to the server. The client is closed
+// when the test ends.
+func (s *Server) Dial(t testing.TB) *varlink.Client {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", s.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("net.Dial() = %v", err)
+	}
+	c := varlink.NewClient(conn)
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// NewClient starts a server with the handler h and returns a client connected
+// to it. The server and the client are closed when the test ends.
+func NewClient(t testing.TB, h varlink.Handler) *varlink.Client {
+	t.Helper()
+	return NewServer(t, &varlink.Server{Handler: h}).Dial(t)
+}
@@ -0,0 +1,82 @@
+package varlinktest_test
+
+import (
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/emersion/go-varlink"
+	"github.com/emersion/go-varlink/varlinktest"
+)
+
+func TestRecordingHandler(t *testing.T) {
+	h := &varlinktest.RecordingHandler{
+		Replies: map[string][]varlinktest.Reply{
+			"org.example.test.Count": {
+				{Parameters: map[string]int{"n": 1}},
+				{Parameters: map[string]int{"n": 2}},
+			},
+			"org.example.test.Fail": {
+				{Error: &varlink.ServerError{Name: "org.example.test.Failed"}},
+			},
+		},
+	}
+	c := varlinktest.NewClient(t, h)
+
+	if err := c.Do("org.example.test.Ping", map[string]string{"msg": "hi"}, nil); err != nil {
+		t.Fatalf("Do(Ping) = %v", err)
+	}
+
+	// Without more, only the last reply is sent
+	var out struct{ N int }
+	if err := c.Do("org.example.test.Count", nil, &out); err != nil {
+		t.Fatalf("Do(Count) = %v", err)
+	} else if out.N != 2 {
+		t.Errorf("Do(Count) = %v, want 2", out.N)
+	}
+
+	cc, err := c.DoMore("org.example.test.Count", nil)
+	if err != nil {
+		t.Fatalf("DoMore(Count) = %v", err)
+	}
+	var got []int
+	for {
+		if err := cc.Next(&out); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Next() = %v", err)
+		}
+		got = append(got, out.N)
+	}
+	if len(got) != 2 || got[0] != 1 || got[1] != 2 {
+		t.Errorf("DoMore(Count) replies = %v, want [1 2]", got)
+	}
+
+	var clientErr *varlink.ClientError
+	err = c.Do("org.example.test.Fail", nil, nil)
+	if !errors.As(err, &clientErr) || clientErr.Name != "org.example.test.Failed" {
+		t.Errorf("Do(Fail) = %v, want org.example.test.Failed", err)
+	}
+
+	calls := h.RecordedCalls()
+	wantMethods := []string{
+		"org.example.test.Ping",
+		"org.example.test.Count",
+		"org.example.test.Count",
+		"org.example.test.Fail",
+	}
+	if len(calls) != len(wantMethods) {
+		t.Fatalf("RecordedCalls() = %v, want %v calls", calls, len(wantMethods))
+	}
+	for i, call := range calls {
+		if call.Method != wantMethods[i] {
+			t.Errorf("RecordedCalls()[%v].Method = %q, want %q", i, call.Method, wantMethods[i])
+		}
+	}
+	if want := `{"msg":"hi"}`; string(calls[0].Parameters) != want {
+		t.Errorf("RecordedCalls()[0].Parameters = %s, want %s", calls[0].Parameters, want)
+	}
+	if calls[1].More || !calls[2].More {
+		t.Errorf("RecordedCalls() more flags = %v, %v, want false, true", calls[1].More, calls[2].More)
+	}
+}